@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hareku/smart-syncer/pkg/syncer"
+	"github.com/urfave/cli/v2"
+)
+
+var cleanupMultipartCommand = &cli.Command{
+	Name:  "cleanup-multipart",
+	Usage: "abort incomplete multipart uploads left by interrupted runs",
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "older-than",
+			Usage: "abort uploads initiated more than the period ago, to leave ones of running syncs",
+			Value: 24 * time.Hour,
+		},
+	},
+	Action: func(c *cli.Context) error {
+		repo, err := openRepository(c)
+		if err != nil {
+			return err
+		}
+		mr, ok := repo.(syncer.MultipartRepository)
+		if !ok {
+			return fmt.Errorf("repository does not support multipart uploads")
+		}
+
+		keys, err := mr.AbortMultipartUploads(c.Context, time.Now().Add(-c.Duration("older-than")))
+		for _, k := range keys {
+			log.Printf("Aborted: %s", k)
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("Aborted %d uploads", len(keys))
+		return nil
+	},
+}
@@ -2,113 +2,237 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"os/signal"
 	"runtime"
-	"time"
+	"runtime/debug"
+	"strings"
+	"syscall"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/hareku/smart-syncer/pkg/syncer"
 	"github.com/urfave/cli/v2"
 )
 
+// version is the version of smart-syncer, which is set by -ldflags "-X main.version=v1.2.3" on release builds.
+var version = "dev"
+
 func main() {
+	// binaries installed by "go install" know their module version.
+	if info, ok := debug.ReadBuildInfo(); ok && version == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+
 	app := &cli.App{
-		Name: "smart-syncer",
+		Name:    "smart-syncer",
+		Version: version,
 		Flags: []cli.Flag{
 			&cli.StringFlag{
-				Name:     "src",
+				Name:     "repo",
+				Usage:    "repository URL such as \"s3://bucket/prefix?region=us-east-1\" and \"file:///mnt/backup\"",
 				Required: true,
 			},
-			&cli.UintFlag{
-				Name:     "depth",
-				Required: true,
+			&cli.StringFlag{
+				Name:    "endpoint",
+				Usage:   "endpoint of an S3 compatible service, such as \"http://127.0.0.1:9000\" for MinIO",
+				EnvVars: []string{"SMART_SYNCER_ENDPOINT"},
+			},
+			&cli.BoolFlag{
+				Name:    "path-style",
+				Usage:   "use path style addressing of S3, which S3 compatible services often require",
+				EnvVars: []string{"SMART_SYNCER_PATH_STYLE"},
 			},
 			&cli.StringFlag{
-				Name:     "region",
-				Required: true,
+				Name:    "profile",
+				Usage:   "profile of the AWS shared config and credentials files",
+				EnvVars: []string{"SMART_SYNCER_PROFILE"},
 			},
 			&cli.StringFlag{
-				Name:     "bucket",
-				Required: true,
+				Name:    "access-key",
+				Usage:   "access key of S3 (default: standard AWS credential chain)",
+				EnvVars: []string{"SMART_SYNCER_ACCESS_KEY"},
 			},
 			&cli.StringFlag{
-				Name:     "prefix",
-				Required: true,
+				Name:    "secret-key",
+				Usage:   "secret key of S3",
+				EnvVars: []string{"SMART_SYNCER_SECRET_KEY"},
+			},
+			&cli.StringFlag{
+				Name:    "role-arn",
+				Usage:   "ARN of the role to assume",
+				EnvVars: []string{"SMART_SYNCER_ROLE_ARN"},
+			},
+			&cli.StringFlag{
+				Name:    "external-id",
+				Usage:   "external ID to assume the role with",
+				EnvVars: []string{"SMART_SYNCER_EXTERNAL_ID"},
+			},
+			&cli.IntFlag{
+				Name:  "concurrency",
+				Usage: "number of units processed concurrently (default: number of CPUs up to 5)",
+			},
+			&cli.IntFlag{
+				Name:  "part-concurrency",
+				Usage: "number of concurrent part uploads for each multipart upload (default: number of CPUs up to 5)",
+			},
+			&cli.StringFlag{
+				Name:    "passphrase",
+				Usage:   "encrypt archives with a key derived from the passphrase",
+				EnvVars: []string{"SMART_SYNCER_PASSPHRASE"},
+			},
+			&cli.StringFlag{
+				Name:  "key-file",
+				Usage: "encrypt archives with a key derived from the content of the file",
 			},
 			&cli.BoolFlag{
-				Name: "dryrun",
+				Name:  "index",
+				Usage: "list objects from the index object in the repository instead of listing the repository",
+			},
+			&cli.StringSliceFlag{
+				Name:  "tag",
+				Usage: "add the tag \"key=value\" to uploaded S3 objects, which may be repeated",
 			},
 			&cli.BoolFlag{
-				Name:  "minio",
-				Usage: "use minio instead of s3",
+				Name:  "rebuild-index",
+				Usage: "rebuild the index from a listing of the repository, implies -index",
 			},
 		},
-		Action: func(c *cli.Context) error {
-			var cfg *aws.Config
-			if c.Bool("minio") {
-				cfg = &aws.Config{
-					Credentials:      credentials.NewStaticCredentials("minio", "minio123", ""),
-					Region:           aws.String(c.String("region")),
-					Endpoint:         aws.String("http://127.0.0.1:9000"),
-					S3ForcePathStyle: aws.Bool(true),
-				}
-			} else {
-				cfg = aws.NewConfig().WithRegion(c.String("region"))
-			}
-			s3Client := s3.New(session.Must(session.NewSession(cfg)))
+		Commands: []*cli.Command{
+			syncCommand,
+			planCommand,
+			applyCommand,
+			restoreCommand,
+			purgeTrashCommand,
+			snapshotsCommand,
+			pruneCommand,
+			cleanupMultipartCommand,
+			verifyCommand,
+		},
+	}
+
+	// on the first signal, commands stop starting new work and finish or abort work in flight.
+	// the second one kills the process as usual.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+		log.Printf("Interrupted, shutting down (interrupt again to exit immediately)")
+	}()
 
-			concurrency := runtime.NumCPU()
-			if concurrency > 5 {
-				concurrency = 5
+	if err := app.RunContext(ctx, os.Args); err != nil {
+		var failedErr *syncer.UnitsFailedError
+		if errors.As(err, &failedErr) {
+			for _, f := range failedErr.Failures {
+				log.Printf("Failed: %s: %v", f.Unit, f.Err)
 			}
-			log.Printf("Running concurrency: %d", concurrency)
+			log.Printf("%d of %d units failed to upload", len(failedErr.Failures), failedErr.Total)
+			os.Exit(exitUnitsFailed)
+		}
+		log.Fatal(err)
+	}
+	os.Exit(0)
+}
 
-			uploader := s3manager.NewUploaderWithClient(s3Client)
-			uploader.Concurrency = concurrency
+// exitUnitsFailed is the exit code when some units failed with --keep-going, while the others were synced.
+const exitUnitsFailed = 3
 
-			client := &syncer.Client{
-				Concurrency:  concurrency,
-				LocalStorage: syncer.NewLocalStorage(),
-				Archiver:     syncer.NewArchiver(),
-				Repository: syncer.NewRepositoryS3(&syncer.NewRepositoryS3Input{
-					Bucket:   c.String("bucket"),
-					Prefix:   c.String("prefix"),
-					API:      s3Client,
-					Uploader: uploader,
-				}),
-				Dryrun: c.Bool("dryrun"),
-			}
+// concurrency returns the value of the flag, or the default one if not given.
+func concurrency(c *cli.Context, name string) int {
+	if n := c.Int(name); n > 0 {
+		return n
+	}
+	n := runtime.NumCPU()
+	if n > 5 {
+		n = 5
+	}
+	return n
+}
 
-			if c.Int("depth") < 1 {
-				return fmt.Errorf("option -depth must be greater than 0")
-			}
+// newEncryption returns the encryption specified by the flags, or nil if not specified.
+func newEncryption(c *cli.Context) (syncer.Encryption, error) {
+	switch {
+	case c.String("passphrase") != "" && c.String("key-file") != "":
+		return nil, fmt.Errorf("options -passphrase and -key-file are exclusive")
+	case c.String("passphrase") != "":
+		return syncer.NewPassphraseEncryption(c.String("passphrase"))
+	case c.String("key-file") != "":
+		secret, err := os.ReadFile(c.String("key-file"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+		return syncer.NewAESGCMEncryption(secret), nil
+	}
+	return nil, nil
+}
 
-			begin := time.Now()
-			if err := client.Run(context.Background(), &syncer.ClientRunInput{
-				Path:  c.String("src"),
-				Depth: c.Int("depth"),
-			}); err != nil {
-				return err
-			}
-			log.Printf("Done in %v", time.Since(begin))
+// newRepository opens the repository, with the index if enabled by the flags.
+func newRepository(c *cli.Context) (syncer.Repository, error) {
+	repo, err := openRepository(c)
+	if err != nil {
+		return nil, err
+	}
+	if !c.Bool("index") && !c.Bool("rebuild-index") {
+		return repo, nil
+	}
+	ir := syncer.NewIndexRepository(repo)
+	if c.Bool("rebuild-index") {
+		if err := ir.RebuildIndex(c.Context); err != nil {
+			return nil, err
+		}
+	}
+	return ir, nil
+}
 
-			stat := runtime.MemStats{}
-			runtime.ReadMemStats(&stat)
-			log.Printf("Allocated memory: %d bytes", stat.Alloc)
-			log.Printf("Allocated heap: %d bytes", stat.HeapAlloc)
-			log.Printf("Allocated total: %d bytes", stat.TotalAlloc)
-			return nil
-		},
+// openRepository opens the repository without the index.
+// S3 repositories are opened with the options from flags, and others through the registry.
+func openRepository(c *cli.Context) (syncer.Repository, error) {
+	u, err := url.Parse(c.String("repo"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse repository URL: %w", err)
+	}
+	if u.Scheme != "s3" {
+		return syncer.OpenRepository(c.Context, c.String("repo"))
 	}
 
-	if err := app.Run(os.Args); err != nil {
-		log.Fatal(err)
+	cfg := aws.NewConfig()
+	if v := c.String("endpoint"); v != "" {
+		cfg = cfg.WithEndpoint(v)
 	}
-	os.Exit(0)
+	if c.Bool("path-style") {
+		cfg = cfg.WithS3ForcePathStyle(true)
+	}
+	switch ak, sk := c.String("access-key"), c.String("secret-key"); {
+	case ak != "" && sk != "":
+		cfg = cfg.WithCredentials(credentials.NewStaticCredentials(ak, sk, ""))
+	case ak != "" || sk != "":
+		return nil, fmt.Errorf("options -access-key and -secret-key must be given together")
+	}
+
+	tags := map[string]string{}
+	for _, t := range c.StringSlice("tag") {
+		kv := strings.SplitN(t, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid tag %q, which must be \"key=value\"", t)
+		}
+		tags[kv[0]] = kv[1]
+	}
+
+	repo, err := syncer.NewRepositoryS3Opener(&syncer.RepositoryS3Options{
+		Config:          cfg,
+		Profile:         c.String("profile"),
+		RoleARN:         c.String("role-arn"),
+		ExternalID:      c.String("external-id"),
+		Concurrency:     concurrency(c, "concurrency"),
+		PartConcurrency: concurrency(c, "part-concurrency"),
+		Tags:            tags,
+	})(c.Context, u)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open s3 repository: %w", err)
+	}
+	return repo, nil
 }
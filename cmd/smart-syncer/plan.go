@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/hareku/smart-syncer/pkg/syncer"
+	"github.com/urfave/cli/v2"
+)
+
+var planCommand = &cli.Command{
+	Name:  "plan",
+	Usage: "show what sync would do without changing anything",
+	Flags: joinFlags([]cli.Flag{
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "output format, \"table\" or \"json\"",
+			Value: "table",
+		},
+	}, planFlags, filterFlags, applyFlags),
+	Action: func(c *cli.Context) error {
+		in, err := newRunInput(c)
+		if err != nil {
+			return err
+		}
+		client, err := newSyncClient(c, in.Path)
+		if err != nil {
+			return err
+		}
+
+		plan, err := client.Plan(c.Context, in)
+		if err != nil {
+			return err
+		}
+
+		switch c.String("format") {
+		case "table":
+			return printPlan(os.Stdout, plan)
+		case "json":
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(plan)
+		}
+		return fmt.Errorf("unknown format %q", c.String("format"))
+	},
+}
+
+var applyCommand = &cli.Command{
+	Name:  "apply",
+	Usage: "apply a plan printed by \"plan --format json\"",
+	Flags: joinFlags([]cli.Flag{
+		&cli.StringFlag{
+			Name:     "plan",
+			Usage:    "JSON file of the plan, or \"-\" for stdin",
+			Required: true,
+		},
+	}, filterFlags, applyFlags),
+	Action: func(c *cli.Context) error {
+		var r io.Reader = os.Stdin
+		if p := c.String("plan"); p != "-" {
+			f, err := os.Open(p)
+			if err != nil {
+				return fmt.Errorf("failed to open plan: %w", err)
+			}
+			defer f.Close()
+			r = f
+		}
+		plan := &syncer.SyncPlan{}
+		if err := json.NewDecoder(r).Decode(plan); err != nil {
+			return fmt.Errorf("failed to decode plan: %w", err)
+		}
+
+		// filters must be the same as planning to archive the same files
+		client, err := newSyncClient(c, plan.Path)
+		if err != nil {
+			return err
+		}
+		return client.Apply(c.Context, plan)
+	},
+}
+
+func printPlan(w io.Writer, plan *syncer.SyncPlan) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACTION\tUNIT\tKEY\tREASON")
+	for _, a := range plan.Actions {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", a.Type, a.Unit, a.Key, a.Reason)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\n%d new, %d changed, %d deleted, %d skipped\n",
+		plan.Count(syncer.SyncActionUploadNew),
+		plan.Count(syncer.SyncActionUploadChanged),
+		plan.Count(syncer.SyncActionDelete),
+		plan.Count(syncer.SyncActionSkip),
+	)
+	return err
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hareku/smart-syncer/pkg/syncer"
+	"github.com/urfave/cli/v2"
+)
+
+var purgeTrashCommand = &cli.Command{
+	Name:  "purge-trash",
+	Usage: "delete units which were soft-deleted before the period",
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:     "older-than",
+			Usage:    "purge units trashed more than the period ago, such as \"720h\"",
+			Required: true,
+		},
+	},
+	Action: func(c *cli.Context) error {
+		repo, err := newRepository(c)
+		if err != nil {
+			return err
+		}
+		tr, ok := repo.(syncer.TrashRepository)
+		if !ok {
+			return fmt.Errorf("repository does not support trash")
+		}
+
+		keys, err := tr.PurgeTrash(c.Context, time.Now().Add(-c.Duration("older-than")))
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			log.Printf("Purged: %s", k)
+		}
+		log.Printf("Purged %d objects", len(keys))
+		return nil
+	},
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/hareku/smart-syncer/pkg/syncer"
+	"github.com/urfave/cli/v2"
+)
+
+var restoreCommand = &cli.Command{
+	Name:  "restore",
+	Usage: "download archives and extract them",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "dest",
+			Usage:    "directory to restore units into",
+			Required: true,
+		},
+		&cli.StringSliceFlag{
+			Name:  "key",
+			Usage: "unit to restore, such as \"photos/2023\" (default: all units)",
+		},
+		&cli.StringFlag{
+			Name:  "snapshot",
+			Usage: "ID of the snapshot to restore from, or \"latest\"",
+		},
+		&cli.BoolFlag{
+			Name:  "preserve-owner",
+			Usage: "restore the owner and group of files recorded by sync --preserve-owner, if permitted",
+		},
+		&cli.BoolFlag{
+			Name:  "xattrs",
+			Usage: "restore extended attributes recorded by sync --xattrs",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		enc, err := newEncryption(c)
+		if err != nil {
+			return err
+		}
+		repo, err := newRepository(c)
+		if err != nil {
+			return err
+		}
+
+		concurrency := concurrency(c, "concurrency")
+		log.Printf("Running concurrency: %d", concurrency)
+
+		client := &syncer.Client{
+			Concurrency: concurrency,
+			Extractor: syncer.NewExtractor(&syncer.NewExtractorInput{
+				PreserveOwner: c.Bool("preserve-owner"),
+				Xattrs:        c.Bool("xattrs"),
+			}),
+			Repository: repo,
+			Encryption: enc,
+		}
+
+		begin := time.Now()
+		if err := client.Restore(c.Context, &syncer.ClientRestoreInput{
+			Path:     c.String("dest"),
+			Keys:     c.StringSlice("key"),
+			Snapshot: c.String("snapshot"),
+		}); err != nil {
+			return err
+		}
+		log.Printf("Done in %v", time.Since(begin))
+		return nil
+	},
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hareku/smart-syncer/pkg/syncer"
+	"github.com/urfave/cli/v2"
+)
+
+var snapshotsCommand = &cli.Command{
+	Name:  "snapshots",
+	Usage: "list snapshots from the oldest",
+	Action: func(c *cli.Context) error {
+		repo, err := newRepository(c)
+		if err != nil {
+			return err
+		}
+
+		client := &syncer.Client{
+			Repository: repo,
+		}
+		ids, err := client.ListSnapshots(c.Context)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			fmt.Println(id)
+		}
+		return nil
+	},
+}
+
+var pruneCommand = &cli.Command{
+	Name:  "prune",
+	Usage: "delete snapshots which are not kept by the retention policy",
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:  "keep-last",
+			Usage: "keep the last n snapshots",
+		},
+		&cli.IntFlag{
+			Name:  "keep-daily",
+			Usage: "keep the last snapshot of each of the last n days",
+		},
+		&cli.IntFlag{
+			Name:  "keep-weekly",
+			Usage: "keep the last snapshot of each of the last n weeks",
+		},
+		&cli.IntFlag{
+			Name:  "keep-monthly",
+			Usage: "keep the last snapshot of each of the last n months",
+		},
+		&cli.DurationFlag{
+			Name:  "min-age",
+			Usage: "keep archives which no snapshot refers to if they were uploaded within the duration, because a sync may be running",
+			Value: 24 * time.Hour,
+		},
+		&cli.BoolFlag{
+			Name: "dryrun",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		repo, err := newRepository(c)
+		if err != nil {
+			return err
+		}
+
+		client := &syncer.Client{
+			Repository: repo,
+			Dryrun:     c.Bool("dryrun"),
+		}
+		return client.Prune(c.Context, &syncer.ClientPruneInput{
+			KeepLast:    c.Int("keep-last"),
+			KeepDaily:   c.Int("keep-daily"),
+			KeepWeekly:  c.Int("keep-weekly"),
+			KeepMonthly: c.Int("keep-monthly"),
+			MinAge:      c.Duration("min-age"),
+		})
+	},
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/hareku/smart-syncer/pkg/syncer"
+	"github.com/urfave/cli/v2"
+)
+
+// planFlags are options of sync which affect the plan.
+var planFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:     "src",
+		Required: true,
+	},
+	&cli.UintFlag{
+		Name:     "depth",
+		Required: true,
+	},
+	&cli.BoolFlag{
+		Name:  "fingerprint",
+		Usage: "detect changes by content digest instead of modification time",
+	},
+	&cli.BoolFlag{
+		Name:  "snapshot",
+		Usage: "upload changed units into a new snapshot instead of overwriting archives",
+	},
+	&cli.StringFlag{
+		Name:  "marker-file",
+		Usage: "abort unless the file exists under --src",
+	},
+	&cli.IntFlag{
+		Name:  "min-units",
+		Usage: "abort if --src has fewer units than this",
+	},
+	&cli.StringFlag{
+		Name:  "mount-point",
+		Usage: "abort unless the path is mounted and --src is on it",
+	},
+	&cli.BoolFlag{
+		Name:  "allow-empty",
+		Usage: "allow --src without units to delete every unit in the repository",
+	},
+}
+
+// filterFlags are options of sync which select files and how they are read, affecting both the plan and archives.
+var filterFlags = []cli.Flag{
+	&cli.StringSliceFlag{
+		Name:  "exclude",
+		Usage: "exclude files matching the gitignore-style pattern, such as \"node_modules/\" and \"*.tmp\"",
+	},
+	&cli.StringSliceFlag{
+		Name:  "include",
+		Usage: "include files matching the pattern even if they are excluded",
+	},
+	&cli.StringSliceFlag{
+		Name:  "exclude-from",
+		Usage: "read exclude patterns from the file in the gitignore format",
+	},
+	&cli.BoolFlag{
+		Name:  "follow-symlinks",
+		Usage: "archive targets of symlinks instead of symlinks themselves",
+	},
+}
+
+// applyFlags are options of sync which affect applying the plan.
+var applyFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name: "dryrun",
+	},
+	&cli.StringFlag{
+		Name:  "compression",
+		Usage: "compression of archives, one of \"none\", \"gzip\" and \"zstd\"",
+		Value: "none",
+	},
+	&cli.IntFlag{
+		Name:  "max-delete",
+		Usage: "abort if more units than this would be deleted (default: unlimited)",
+	},
+	&cli.Float64Flag{
+		Name:  "max-delete-percent",
+		Usage: "abort if more than this percentage of units in the repository would be deleted (default: unlimited)",
+	},
+	&cli.BoolFlag{
+		Name:  "soft-delete",
+		Usage: "move deleted units into \".trash/<timestamp>/\" instead of deleting them",
+	},
+	&cli.BoolFlag{
+		Name:  "preserve-owner",
+		Usage: "record the owner and group of files in archives",
+	},
+	&cli.BoolFlag{
+		Name:  "keep-going",
+		Usage: "continue with other units when a unit fails, and exit with code 3 after syncing them",
+	},
+	&cli.DurationFlag{
+		Name:  "grace-period",
+		Usage: "on interrupt, how long uploads in flight may continue before they are aborted",
+		Value: 30 * time.Second,
+	},
+	&cli.IntFlag{
+		Name:  "retries",
+		Usage: "retry a unit up to this many times when it fails by a transient error, such as throttling and network errors",
+		Value: 3,
+	},
+	&cli.DurationFlag{
+		Name:  "retry-delay",
+		Usage: "delay before the first retry, doubled for each retry up to a minute",
+		Value: time.Second,
+	},
+	&cli.BoolFlag{
+		Name:  "xattrs",
+		Usage: "record extended attributes and ACLs of files in archives (Linux only)",
+	},
+}
+
+var syncCommand = &cli.Command{
+	Name:  "sync",
+	Usage: "upload changed units and delete removed ones",
+	Flags: joinFlags([]cli.Flag{
+		&cli.BoolFlag{
+			Name:  "resume",
+			Usage: "record progress in a local journal, and resume the interrupted run of the same --src and --depth",
+		},
+	}, planFlags, filterFlags, applyFlags),
+	Action: func(c *cli.Context) error {
+		in, err := newRunInput(c)
+		if err != nil {
+			return err
+		}
+		client, err := newSyncClient(c, in.Path)
+		if err != nil {
+			return err
+		}
+		if c.Bool("resume") {
+			dir, err := syncer.DefaultJournalDir(c.String("repo"))
+			if err != nil {
+				return err
+			}
+			client.Journal, err = syncer.OpenJournal(dir)
+			if err != nil {
+				return err
+			}
+		}
+
+		begin := time.Now()
+		if err := client.Run(c.Context, in); err != nil {
+			return err
+		}
+		log.Printf("Done in %v", time.Since(begin))
+
+		stat := runtime.MemStats{}
+		runtime.ReadMemStats(&stat)
+		log.Printf("Allocated memory: %d bytes", stat.Alloc)
+		log.Printf("Allocated heap: %d bytes", stat.HeapAlloc)
+		log.Printf("Allocated total: %d bytes", stat.TotalAlloc)
+		return nil
+	},
+}
+
+func joinFlags(sets ...[]cli.Flag) []cli.Flag {
+	res := []cli.Flag{}
+	for _, s := range sets {
+		res = append(res, s...)
+	}
+	return res
+}
+
+// newSyncClient returns a client configured by the flags of sync, for the source directory src.
+func newSyncClient(c *cli.Context, src string) (*syncer.Client, error) {
+	comp, err := syncer.NewCompression(c.String("compression"))
+	if err != nil {
+		return nil, err
+	}
+	filter, err := newFilter(c, src)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := newEncryption(c)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := newRepository(c)
+	if err != nil {
+		return nil, err
+	}
+
+	concurrency := concurrency(c, "concurrency")
+	log.Printf("Running concurrency: %d", concurrency)
+
+	// the hostname is informational, so that archives are uploaded without it if unknown.
+	hostname, _ := os.Hostname()
+
+	return &syncer.Client{
+		Concurrency: concurrency,
+		LocalStorage: syncer.NewLocalStorage(&syncer.NewLocalStorageInput{
+			Filter:         filter,
+			FollowSymlinks: c.Bool("follow-symlinks"),
+		}),
+		Archiver: syncer.NewArchiver(&syncer.NewArchiverInput{
+			Filter:         filter,
+			FollowSymlinks: c.Bool("follow-symlinks"),
+			PreserveOwner:  c.Bool("preserve-owner"),
+			Xattrs:         c.Bool("xattrs"),
+		}),
+		Repository:  repo,
+		Dryrun:      c.Bool("dryrun"),
+		Fingerprint: c.Bool("fingerprint"),
+		Compression: comp,
+		Encryption:  enc,
+
+		MaxDelete:        c.Int("max-delete"),
+		MaxDeletePercent: c.Float64("max-delete-percent"),
+		SoftDelete:       c.Bool("soft-delete"),
+		Snapshot:         c.Bool("snapshot"),
+		GracePeriod:      c.Duration("grace-period"),
+		KeepGoing:        c.Bool("keep-going"),
+		Retry: &syncer.RetryPolicy{
+			MaxAttempts: c.Int("retries") + 1,
+			BaseDelay:   c.Duration("retry-delay"),
+			MaxDelay:    time.Minute,
+		},
+		Version:  version,
+		Hostname: hostname,
+		SourceCheck: &syncer.SourceCheck{
+			MarkerFile: c.String("marker-file"),
+			MinObjects: c.Int("min-units"),
+			MountPoint: c.String("mount-point"),
+		},
+		AllowEmpty: c.Bool("allow-empty"),
+	}, nil
+}
+
+// newFilter returns the filter of the flags in filterFlags, for the source directory src.
+func newFilter(c *cli.Context, src string) (*syncer.Filter, error) {
+	return syncer.NewFilter(&syncer.NewFilterInput{
+		Root:        src,
+		Excludes:    c.StringSlice("exclude"),
+		Includes:    c.StringSlice("include"),
+		ExcludeFrom: c.StringSlice("exclude-from"),
+	})
+}
+
+func newRunInput(c *cli.Context) (*syncer.ClientRunInput, error) {
+	if c.Int("depth") < 1 {
+		return nil, fmt.Errorf("option -depth must be greater than 0")
+	}
+	return &syncer.ClientRunInput{
+		Path:  c.String("src"),
+		Depth: c.Int("depth"),
+	}, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/hareku/smart-syncer/pkg/syncer"
+	"github.com/urfave/cli/v2"
+)
+
+var verifyCommand = &cli.Command{
+	Name:  "verify",
+	Usage: "download archives and compare them with the source",
+	Flags: joinFlags([]cli.Flag{
+		&cli.StringFlag{
+			Name:     "src",
+			Required: true,
+		},
+		&cli.UintFlag{
+			Name:     "depth",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "snapshot",
+			Usage: "ID of the snapshot to verify, or \"latest\"",
+		},
+	}, filterFlags),
+	Action: func(c *cli.Context) error {
+		in, err := newRunInput(c)
+		if err != nil {
+			return err
+		}
+		filter, err := newFilter(c, in.Path)
+		if err != nil {
+			return err
+		}
+		enc, err := newEncryption(c)
+		if err != nil {
+			return err
+		}
+		repo, err := newRepository(c)
+		if err != nil {
+			return err
+		}
+
+		concurrency := concurrency(c, "concurrency")
+		log.Printf("Running concurrency: %d", concurrency)
+
+		// the archiver must select the same files as sync, but owners and xattrs do not matter.
+		client := &syncer.Client{
+			Concurrency: concurrency,
+			LocalStorage: syncer.NewLocalStorage(&syncer.NewLocalStorageInput{
+				Filter:         filter,
+				FollowSymlinks: c.Bool("follow-symlinks"),
+			}),
+			Archiver: syncer.NewArchiver(&syncer.NewArchiverInput{
+				Filter:         filter,
+				FollowSymlinks: c.Bool("follow-symlinks"),
+			}),
+			Repository: repo,
+			Encryption: enc,
+		}
+		res, err := client.Verify(c.Context, &syncer.ClientVerifyInput{
+			Path:     in.Path,
+			Depth:    in.Depth,
+			Snapshot: c.String("snapshot"),
+		})
+		if err != nil {
+			return err
+		}
+
+		failed := printVerifications(os.Stdout, res)
+		if failed > 0 {
+			return fmt.Errorf("%d of %d units do not match", failed, len(res))
+		}
+		log.Printf("All %d units match", len(res))
+		return nil
+	},
+}
+
+// printVerifications prints units which do not match with their differences, and returns the number of them.
+func printVerifications(w io.Writer, res []syncer.UnitVerification) int {
+	failed := 0
+	for _, v := range res {
+		switch {
+		case v.OK():
+			continue
+		case v.Key == "":
+			fmt.Fprintf(w, "%s: not in repository\n", v.Unit)
+		case !v.Local:
+			fmt.Fprintf(w, "%s: not in source (%s)\n", v.Unit, v.Key)
+		default:
+			fmt.Fprintf(w, "%s: %d missing, %d extra, %d mismatched (%s)\n", v.Unit, len(v.Missing), len(v.Extra), len(v.Mismatched), v.Key)
+			for _, name := range v.Missing {
+				fmt.Fprintf(w, "  missing    %s\n", name)
+			}
+			for _, name := range v.Extra {
+				fmt.Fprintf(w, "  extra      %s\n", name)
+			}
+			for _, name := range v.Mismatched {
+				fmt.Fprintf(w, "  mismatched %s\n", name)
+			}
+		}
+		failed++
+	}
+	return failed
+}
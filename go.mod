@@ -5,8 +5,10 @@ go 1.17
 require (
 	github.com/aws/aws-sdk-go v1.43.0
 	github.com/golang/mock v1.6.0
+	github.com/klauspost/compress v1.15.0
 	github.com/stretchr/testify v1.7.0
 	github.com/urfave/cli/v2 v2.3.0
+	golang.org/x/crypto v0.0.0-20220214200702-86341886e292
 	golang.org/x/mod v0.5.1
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
 )
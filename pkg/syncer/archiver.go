@@ -17,8 +17,28 @@ type Archiver interface {
 	Do(ctx context.Context, root string, w io.Writer) error
 }
 
-func NewArchiver() Archiver {
-	return &archiver{}
+type NewArchiverInput struct {
+	// Filter excludes files from archives, if not nil.
+	Filter *Filter
+	// FollowSymlinks archives targets of symlinks instead of symlinks themselves.
+	FollowSymlinks bool
+	// PreserveOwner records the owner and group of files. Otherwise they are zeroed.
+	PreserveOwner bool
+	// Xattrs records extended attributes of files, including POSIX ACLs. It is supported only on Linux.
+	Xattrs bool
+}
+
+// NewArchiver returns an Archiver which writes regular files, directories and symlinks.
+// Other types of files such as sockets and devices are skipped.
+func NewArchiver(in *NewArchiverInput) Archiver {
+	return &archiver{
+		walker: walker{
+			filter:         in.Filter,
+			followSymlinks: in.FollowSymlinks,
+		},
+		preserveOwner: in.PreserveOwner,
+		xattrs:        in.Xattrs,
+	}
 }
 
 // pool for io.CopyBuffer
@@ -29,60 +49,115 @@ var bufPool = sync.Pool{
 	},
 }
 
-type archiver struct{}
+// paxXattrPrefix is the prefix of PAX records of extended attributes, which GNU tar and bsdtar understand.
+const paxXattrPrefix = "SCHILY.xattr."
+
+type archiver struct {
+	walker        walker
+	preserveOwner bool
+	xattrs        bool
+}
 
 func (a *archiver) Do(ctx context.Context, root string, w io.Writer) error {
 	tw := tar.NewWriter(w)
 
-	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if d.IsDir() {
-			return nil
-		}
-
-		rel, err := filepath.Rel(root, path)
-		if err != nil {
-			return fmt.Errorf("failed to get relative path: %w", err)
+	err := a.walker.walk(ctx, root, func(path string, rel string, info fs.FileInfo) error {
+		name := filepath.ToSlash(rel)
+		// the root directory is written as "./", which tells Extractor that the archive is made from a directory.
+		// A single file is named after itself.
+		if rel == "." && !info.IsDir() {
+			name = filepath.Base(root)
 		}
-		// if rel is ".", root is file (not dir).
-		if rel == "." {
-			rel = d.Name()
+		return a.write(ctx, tw, path, name, info)
+	})
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("archiving %q was canceled: %w", root, ctxErr)
 		}
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to close tar writer: %w", err)
+	}
+	return nil
+}
 
-		f, err := os.Open(path)
+// write writes the entry of the file at path, named name in the archive.
+func (a *archiver) write(ctx context.Context, tw *tar.Writer, path string, name string, info fs.FileInfo) error {
+	mode := info.Mode()
+	link := ""
+	switch {
+	case mode.IsRegular(), mode.IsDir():
+	case mode&fs.ModeSymlink != 0:
+		var err error
+		link, err = os.Readlink(path)
 		if err != nil {
-			return fmt.Errorf("failed to read file %q: %w", path, err)
+			return fmt.Errorf("failed to read symlink %q: %w", path, err)
 		}
-		defer f.Close()
+	default:
+		return nil
+	}
 
-		info, err := f.Stat()
-		if err != nil {
-			return fmt.Errorf("failed to stat file %q: %w", path, err)
-		}
-		h, err := tar.FileInfoHeader(info, "")
+	h, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return fmt.Errorf("failed to create tar header for %q: %w", path, err)
+	}
+	h.Name = name
+	if mode.IsDir() {
+		h.Name += "/"
+	}
+	if !a.preserveOwner {
+		h.Uid, h.Gid, h.Uname, h.Gname = 0, 0, "", ""
+	}
+	// extended attributes of a symlink cannot be read without following it.
+	if a.xattrs && link == "" {
+		attrs, err := readXattrs(path)
 		if err != nil {
-			return fmt.Errorf("failed to create tar header for %q: %w", path, err)
+			return err
 		}
-		h.Name = filepath.ToSlash(rel)
-		if err := tw.WriteHeader(h); err != nil {
-			return fmt.Errorf("failed to write tar header %+v: %w", h, err)
+		for k, v := range attrs {
+			if h.PAXRecords == nil {
+				h.PAXRecords = map[string]string{}
+			}
+			h.PAXRecords[paxXattrPrefix+k] = v
 		}
+	}
+	if err := tw.WriteHeader(h); err != nil {
+		return fmt.Errorf("failed to write tar header %+v: %w", h, err)
+	}
+	if !mode.IsRegular() {
+		return nil
+	}
 
-		buf := bufPool.Get().(*[]byte)
-		defer bufPool.Put(buf)
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read file %q: %w", path, err)
+	}
+	defer f.Close()
 
-		if _, err := io.CopyBuffer(tw, f, *buf); err != nil {
-			return fmt.Errorf("failed to write tar content: %w", err)
-		}
-		return nil
-	})
+	buf := bufPool.Get().(*[]byte)
+	defer bufPool.Put(buf)
+
+	// the header has the size at the time of stat, so the file must not be grown or truncated since then.
+	n, err := io.CopyBuffer(tw, &contextReader{ctx: ctx, r: io.LimitReader(f, h.Size)}, *buf)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to write tar content: %w", err)
 	}
-	if err := tw.Close(); err != nil {
-		return fmt.Errorf("failed to close tar writer: %w", err)
+	if n != h.Size {
+		return fmt.Errorf("file %q was truncated while archiving", path)
 	}
 	return nil
 }
+
+// contextReader stops reading once ctx is done, so that copying a large file ends promptly on cancellation.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
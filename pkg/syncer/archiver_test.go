@@ -10,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/hareku/smart-syncer/pkg/syncer"
 	"github.com/stretchr/testify/assert"
@@ -18,7 +19,7 @@ import (
 )
 
 func TestArchiver_Do(t *testing.T) {
-	a := syncer.NewArchiver()
+	a := syncer.NewArchiver(&syncer.NewArchiverInput{})
 
 	targetDir, err := os.MkdirTemp("", "archiver-do-test-target-")
 	require.NoError(t, err)
@@ -54,6 +55,113 @@ func TestArchiver_Do(t *testing.T) {
 	assert.Equal(t, expected, got)
 }
 
+func TestArchiver_Do_Symlinks(t *testing.T) {
+	targetDir, err := os.MkdirTemp("", "archiver-do-symlinks-test-")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, os.RemoveAll(targetDir))
+	})
+	require.NoError(t, os.WriteFile(filepath.Join(targetDir, "abc"), []byte("data for abc"), 0777))
+	require.NoError(t, os.Mkdir(filepath.Join(targetDir, "empty"), 0777))
+	require.NoError(t, os.Symlink("abc", filepath.Join(targetDir, "link")))
+	require.NoError(t, os.Symlink("..", filepath.Join(targetDir, "loop")))
+
+	entries := func(t *testing.T, r io.Reader) map[string]*tar.Header {
+		res := map[string]*tar.Header{}
+		tr := tar.NewReader(r)
+		for {
+			h, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				return res
+			}
+			require.NoError(t, err)
+			res[h.Name] = h
+		}
+	}
+
+	t.Run("preserve", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		require.NoError(t, syncer.NewArchiver(&syncer.NewArchiverInput{}).Do(context.Background(), targetDir, buf))
+
+		got := entries(t, buf)
+		require.Contains(t, got, "empty/")
+		assert.Equal(t, byte(tar.TypeDir), got["empty/"].Typeflag)
+		require.Contains(t, got, "link")
+		assert.Equal(t, byte(tar.TypeSymlink), got["link"].Typeflag)
+		assert.Equal(t, "abc", got["link"].Linkname)
+		require.Contains(t, got, "loop")
+		assert.Equal(t, "..", got["loop"].Linkname)
+		assert.Equal(t, 0, got["abc"].Uid)
+	})
+
+	t.Run("follow", func(t *testing.T) {
+		a := syncer.NewArchiver(&syncer.NewArchiverInput{FollowSymlinks: true})
+		err := a.Do(context.Background(), targetDir, io.Discard)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "symlink loop")
+
+		require.NoError(t, os.Remove(filepath.Join(targetDir, "loop")))
+		buf := &bytes.Buffer{}
+		require.NoError(t, a.Do(context.Background(), targetDir, buf))
+
+		got := entries(t, buf)
+		require.Contains(t, got, "link")
+		assert.Equal(t, byte(tar.TypeReg), got["link"].Typeflag)
+		assert.Equal(t, int64(len("data for abc")), got["link"].Size)
+	})
+}
+
+func TestArchiver_Do_Cancel(t *testing.T) {
+	targetDir, err := os.MkdirTemp("", "archiver-do-cancel-test-")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, os.RemoveAll(targetDir))
+	})
+	require.NoError(t, os.WriteFile(filepath.Join(targetDir, "large"), make([]byte, 16*1024*1024), 0666))
+
+	a := syncer.NewArchiver(&syncer.NewArchiverInput{})
+
+	t.Run("canceled before", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := a.Do(ctx, targetDir, io.Discard)
+		assert.True(t, errors.Is(err, context.Canceled), err)
+	})
+
+	t.Run("canceled while copying", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// the slow reader takes seconds to consume the whole archive.
+		pr, pw := io.Pipe()
+		go func() {
+			buf := make([]byte, 32*1024)
+			for {
+				if _, err := pr.Read(buf); err != nil {
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}()
+
+		done := make(chan error, 1)
+		go func() {
+			err := a.Do(ctx, targetDir, pw)
+			_ = pw.CloseWithError(err)
+			done <- err
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+		select {
+		case err := <-done:
+			assert.True(t, errors.Is(err, context.Canceled), err)
+		case <-time.After(time.Second):
+			t.Fatal("archiver did not stop after cancellation")
+		}
+	})
+}
+
 func hashTar(tarReader io.Reader) (string, error) {
 	tr := tar.NewReader(tarReader)
 
@@ -67,6 +175,10 @@ func hashTar(tarReader io.Reader) (string, error) {
 			}
 			return "", err
 		}
+		// dirhash hashes only regular files.
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
 		files = append(files, h.Name)
 		b, err := io.ReadAll(tr)
 		if err != nil {
@@ -6,7 +6,10 @@ import (
 	"io"
 	"log"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 )
@@ -15,8 +18,73 @@ type Client struct {
 	LocalStorage LocalStorage
 	Repository   Repository
 	Archiver     Archiver
+	Extractor    Extractor
 	Dryrun       bool
-	Concurrency  int
+	// Concurrency is the number of units archived and uploaded concurrently.
+	Concurrency int
+	// Fingerprint enables change detection by content digest instead of modification time.
+	Fingerprint bool
+	// Compression compresses archives before uploading. Archives are not compressed if it is nil.
+	Compression Compression
+	// Encryption encrypts archives after compressing. Archives are not encrypted if it is nil.
+	Encryption Encryption
+	// MaxDelete aborts a run which would delete more units than it, if positive.
+	MaxDelete int
+	// MaxDeletePercent aborts a run which would delete more than the percentage of units in the repository, if positive.
+	MaxDeletePercent float64
+	// SoftDelete moves objects into the trash instead of deleting them. The repository must be a TrashRepository.
+	SoftDelete bool
+	// SourceCheck checks the source before changing the repository, if not nil.
+	SourceCheck *SourceCheck
+	// AllowEmpty allows a source without units to delete every unit in the repository.
+	// Otherwise such a source is refused, because it usually means a wrong source path or an unmounted drive.
+	AllowEmpty bool
+	// Snapshot keeps every version of units by uploading changed units into a new snapshot on each run,
+	// instead of overwriting their archives. Old snapshots are deleted by Prune.
+	Snapshot bool
+	// GracePeriod is how long uploads in flight may continue after the context is canceled,
+	// such as by an interrupt signal. No upload is started after cancellation.
+	GracePeriod time.Duration
+	// Journal records the progress of Run, so that the next Run with the same input resumes it, if not nil.
+	// Uploads to a ResumableRepository also resume from the interrupted part.
+	Journal *Journal
+	// KeepGoing continues with other units when a unit fails to be uploaded, and returns *UnitsFailedError at the end.
+	// Deletions related to failed units and the snapshot are skipped.
+	KeepGoing bool
+	// Retry archives and uploads a unit again when it fails by a retryable error, if not nil.
+	Retry *RetryPolicy
+	// Version and Hostname are recorded in the metadata of uploaded archives, if not empty.
+	Version  string
+	Hostname string
+}
+
+// TooManyDeletesError is returned when a run would delete more units than allowed,
+// which usually means a wrong source path or an unmounted drive.
+type TooManyDeletesError struct {
+	Deletes int
+	Total   int
+}
+
+func (e *TooManyDeletesError) Error() string {
+	return fmt.Sprintf("refusing to delete %d of %d units in repository", e.Deletes, e.Total)
+}
+
+// UnitFailure is a unit which failed to be uploaded.
+type UnitFailure struct {
+	Unit string
+	Err  error
+}
+
+// UnitsFailedError is returned in the KeepGoing mode when some units failed to be uploaded, after the others were synced.
+type UnitsFailedError struct {
+	// Failures are sorted by the unit.
+	Failures []UnitFailure
+	// Total is the number of units which were to be uploaded.
+	Total int
+}
+
+func (e *UnitsFailedError) Error() string {
+	return fmt.Sprintf("%d of %d units failed to upload, first %q: %v", len(e.Failures), e.Total, e.Failures[0].Unit, e.Failures[0].Err)
 }
 
 type ClientRunInput struct {
@@ -24,108 +92,573 @@ type ClientRunInput struct {
 	Depth int
 }
 
+// Run syncs the source with the repository. It is Plan followed by Apply.
+// If the journal has the plan of an interrupted run with the same input, Run applies the rest of it instead of planning.
 func (c *Client) Run(ctx context.Context, in *ClientRunInput) error {
-	repoObjects, err := c.Repository.List(ctx)
+	plan, err := c.resumePlan(in)
 	if err != nil {
-		return fmt.Errorf("failed to list objects from repository: %w", err)
+		return err
 	}
-	inRepo := map[string]RepositoryObject{}
-	for _, obj := range repoObjects {
-		inRepo[strings.TrimSuffix(obj.Key, ".tar")] = obj
+	if plan == nil {
+		plan, err = c.Plan(ctx, in)
+		if err != nil {
+			return err
+		}
+		if !c.Dryrun {
+			if err := c.Journal.begin(in, plan); err != nil {
+				return err
+			}
+		}
 	}
 
-	localObjects, err := c.LocalStorage.List(ctx, in.Path, in.Depth)
-	if err != nil {
-		return fmt.Errorf("failed to list objects from local storage: %w", err)
+	if err := c.Apply(ctx, plan); err != nil {
+		return err
+	}
+	if c.Dryrun {
+		return nil
 	}
+	return c.Journal.finish()
+}
 
-	queue := []LocalObject{}
-	for _, v := range localObjects {
-		localObj := v
+// resumePlan returns the plan of the interrupted run in the journal without completed actions, or nil if there is none.
+func (c *Client) resumePlan(in *ClientRunInput) (*SyncPlan, error) {
+	plan, completed, err := c.Journal.resume(in)
+	if err != nil || plan == nil {
+		return nil, err
+	}
+	if plan.Compression != c.compression().Name() || plan.Encryption != c.encryptionScheme() || (plan.Snapshot != nil) != c.Snapshot {
+		return nil, nil
+	}
+	// the source is checked again, because it may have been unmounted since.
+	if err := c.precheck(in); err != nil {
+		return nil, err
+	}
 
-		repoObj, ok := inRepo[localObj.Key]
-		if ok {
-			delete(inRepo, localObj.Key)
+	actions := make([]SyncAction, 0, len(plan.Actions))
+	for _, a := range plan.Actions {
+		if completed[a.Key] {
+			if a.Type == SyncActionDelete {
+				continue
+			}
+			if a.isUpload() {
+				a.Type = SyncActionSkip
+				a.Reason = "uploaded before interruption"
+			}
 		}
-		if ok && localObj.LastModifiedUnix <= repoObj.LastModifiedUnix {
-			continue
+		actions = append(actions, a)
+	}
+	log.Printf("Resuming the interrupted run with %d completed actions", len(completed))
+	plan.Actions = actions
+	return plan, nil
+}
+
+// listLocal lists the units in the source, checking and fingerprinting them if configured.
+func (c *Client) listLocal(ctx context.Context, in *ClientRunInput) ([]LocalObject, error) {
+	localObjects, err := c.LocalStorage.List(ctx, in.Path, in.Depth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects from local storage: %w", err)
+	}
+	if c.SourceCheck != nil {
+		if err := c.SourceCheck.CheckObjects(in.Path, localObjects); err != nil {
+			return nil, err
 		}
+	}
+	if c.Fingerprint {
+		if err := c.fingerprint(ctx, in.Path, localObjects); err != nil {
+			return nil, fmt.Errorf("failed to fingerprint local objects: %w", err)
+		}
+	}
+	return localObjects, nil
+}
 
-		queue = append(queue, localObj)
+// uploadAll archives and uploads the units of the actions concurrently.
+// In the KeepGoing mode, units which failed are returned instead of an error.
+func (c *Client) uploadAll(ctx context.Context, root string, queue []SyncAction) ([]UnitFailure, error) {
+	if len(queue) == 0 {
+		return nil, nil
 	}
 
-	if len(queue) > 0 {
-		eg, ctx := errgroup.WithContext(ctx)
-		ch := make(chan LocalObject, c.Concurrency)
+	work, cancel := graceContext(ctx, c.GracePeriod)
+	defer cancel()
+	eg, work := errgroup.WithContext(work)
+	ch := make(chan SyncAction)
 
-		eg.Go(func() error {
-			defer close(ch)
-			for i, v := range queue {
-				select {
-				case <-ctx.Done():
-					return fmt.Errorf("uploading cancelled: %w", ctx.Err())
-				case ch <- v:
-					log.Printf("Uploading(%d/%d): %s", i+1, len(queue), v.Key)
-				}
+	// the feeder stops without an error on cancellation, which would cancel uploads in flight.
+	eg.Go(func() error {
+		defer close(ch)
+		for i, v := range queue {
+			if ctx.Err() != nil {
+				return nil
 			}
-			return nil
-		})
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-work.Done():
+				return nil
+			case ch <- v:
+				log.Printf("Uploading(%d/%d): %s", i+1, len(queue), v.Unit)
+			}
+		}
+		return nil
+	})
 
+	var mu sync.Mutex
+	failures := []UnitFailure{}
+	for i := 0; i < c.concurrency(); i++ {
 		eg.Go(func() error {
-			if err := c.upload(ctx, in.Path, ch); err != nil {
+			unitFailures, err := c.upload(work, root, ch)
+			if err != nil {
 				return fmt.Errorf("uploading failed: %w", err)
 			}
+			mu.Lock()
+			failures = append(failures, unitFailures...)
+			mu.Unlock()
 			return nil
 		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("uploading cancelled: %w", err)
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].Unit < failures[j].Unit
+	})
+	return failures, nil
+}
 
-		if err := eg.Wait(); err != nil {
-			return err
+// graceContext returns a context which is canceled the grace period after ctx is done,
+// so that work in flight can finish after cancellation. Values of ctx are not inherited.
+func graceContext(ctx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	if grace <= 0 {
+		return context.WithCancel(ctx)
+	}
+	res, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-res.Done():
+			return
+		}
+		t := time.NewTimer(grace)
+		defer t.Stop()
+		select {
+		case <-t.C:
+			cancel()
+		case <-res.Done():
 		}
+	}()
+	return res, cancel
+}
+
+// saveIndex saves the index of the repository, if it is an IndexRepository.
+func (c *Client) saveIndex(ctx context.Context) error {
+	ir, ok := c.Repository.(IndexRepository)
+	if !ok || c.Dryrun {
+		return nil
 	}
+	return ir.SaveIndex(ctx)
+}
 
-	if len(inRepo) > 0 {
-		keys := make([]string, 0, len(inRepo))
-		for _, v := range inRepo {
-			keys = append(keys, v.Key)
-		}
-		for i, k := range keys {
-			log.Printf("Deleting(%d/%d): %s", i+1, len(keys), k)
+// checkDeletes returns *TooManyDeletesError if deleting units exceeds the limits.
+func (c *Client) checkDeletes(deletes int, total int) error {
+	if c.MaxDelete > 0 && deletes > c.MaxDelete {
+		return &TooManyDeletesError{Deletes: deletes, Total: total}
+	}
+	if c.MaxDeletePercent > 0 && total > 0 && float64(deletes)/float64(total)*100 > c.MaxDeletePercent {
+		return &TooManyDeletesError{Deletes: deletes, Total: total}
+	}
+	return nil
+}
+
+// checkEmpty refuses a source without units if the repository has some, unless AllowEmpty is set.
+func (c *Client) checkEmpty(in *ClientRunInput, localObjects []LocalObject, repoUnits int) error {
+	if c.AllowEmpty || len(localObjects) > 0 || repoUnits == 0 {
+		return nil
+	}
+	return &SourceError{Path: in.Path, Reason: fmt.Sprintf("source has no units, which would remove all %d units in repository", repoUnits)}
+}
+
+// delete deletes the objects, or moves them into the trash if SoftDelete is enabled.
+func (c *Client) delete(ctx context.Context, keys []string) error {
+	if !c.SoftDelete {
+		return c.Repository.Delete(ctx, keys)
+	}
+	tr, ok := c.Repository.(TrashRepository)
+	if !ok {
+		return fmt.Errorf("repository does not support soft deletion")
+	}
+	return tr.Trash(ctx, keys, time.Now())
+}
+
+func (c *Client) compression() Compression {
+	if c.Compression == nil {
+		return &noCompression{}
+	}
+	return c.Compression
+}
+
+// archiveKey returns the repository key which the unit is uploaded to.
+func (c *Client) archiveKey(unit string) string {
+	return archiveKey(unit, c.compression())
+}
+
+// changeReason returns why the local object has to be uploaded again over the repository object,
+// or empty if it is already stored with the current compression and encryption.
+func (c *Client) changeReason(localObj LocalObject, repoObj RepositoryObject) string {
+	_, comp, ok := parseArchiveKey(repoObj.Key)
+	switch {
+	case !ok || comp.Name() != c.compression().Name():
+		return "compression changed"
+	case repoObj.Metadata.Encryption != c.encryptionScheme():
+		return "encryption changed"
+	case c.Fingerprint && localObj.Fingerprint != repoObj.Metadata.Fingerprint:
+		return "content changed"
+	case !c.Fingerprint && repoObj.Metadata.LocalModifiedUnix != 0 && localObj.LastModifiedUnix != repoObj.Metadata.LocalModifiedUnix:
+		return "modified since archived"
+	// archives uploaded without the local modification time are compared by their upload time.
+	case !c.Fingerprint && repoObj.Metadata.LocalModifiedUnix == 0 && localObj.LastModifiedUnix > repoObj.LastModifiedUnix:
+		return "modified after upload"
+	}
+	return ""
+}
+
+// unchangedByListing reports whether the repository object is unchanged from the local object without its metadata,
+// because it has the current compression and was uploaded after the unit was modified last.
+// Fingerprints, encryption and modification times going backwards are compared only with metadata.
+func (c *Client) unchangedByListing(localObj LocalObject, repoObj RepositoryObject) bool {
+	_, comp, ok := parseArchiveKey(repoObj.Key)
+	return ok && comp.Name() == c.compression().Name() && !c.Fingerprint && localObj.LastModifiedUnix <= repoObj.LastModifiedUnix
+}
+
+// fingerprint sets the fingerprint of each local object concurrently.
+func (c *Client) fingerprint(ctx context.Context, root string, objs []LocalObject) error {
+	eg, ctx := errgroup.WithContext(ctx)
+	ch := make(chan *LocalObject)
+
+	eg.Go(func() error {
+		defer close(ch)
+		for i := range objs {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case ch <- &objs[i]:
+			}
 		}
-		if !c.Dryrun {
-			if err := c.Repository.Delete(ctx, keys); err != nil {
-				return fmt.Errorf("failed to delete objects: %w", err)
+		return nil
+	})
+	for i := 0; i < c.concurrency(); i++ {
+		eg.Go(func() error {
+			for obj := range ch {
+				fp, err := c.LocalStorage.Fingerprint(ctx, filepath.Join(root, obj.Key))
+				if err != nil {
+					return fmt.Errorf("failed to fingerprint %q: %w", obj.Key, err)
+				}
+				obj.Fingerprint = fp
 			}
+			return nil
+		})
+	}
+	return eg.Wait()
+}
+
+func (c *Client) concurrency() int {
+	if c.Concurrency < 1 {
+		return 1
+	}
+	return c.Concurrency
+}
+
+// archive writes the archive of path to w, compressing and encrypting it if configured.
+func (c *Client) archive(ctx context.Context, path string, w io.Writer) error {
+	var ew io.WriteCloser = nopWriteCloser{w}
+	if c.Encryption != nil {
+		var err error
+		ew, err = c.Encryption.NewWriter(w)
+		if err != nil {
+			return fmt.Errorf("failed to start encryption: %w", err)
 		}
 	}
 
+	cw, err := c.compression().NewWriter(ew)
+	if err != nil {
+		return fmt.Errorf("failed to start compression: %w", err)
+	}
+	if err := c.Archiver.Do(ctx, path, cw); err != nil {
+		_ = cw.Close() // release resources of the compressor
+		return err
+	}
+	if err := cw.Close(); err != nil {
+		return fmt.Errorf("failed to compress: %w", err)
+	}
+	if err := ew.Close(); err != nil {
+		return fmt.Errorf("failed to encrypt: %w", err)
+	}
 	return nil
 }
 
-func (c *Client) upload(ctx context.Context, root string, ch <-chan LocalObject) error {
-	for localObj := range ch {
+// metadata returns the metadata of the archive uploaded by the action.
+func (c *Client) metadata(action SyncAction) Metadata {
+	format := "tar"
+	if comp := c.compression(); comp.Name() != "none" {
+		format += "+" + comp.Name()
+	}
+	return Metadata{
+		Fingerprint:       action.Fingerprint,
+		Encryption:        c.encryptionScheme(),
+		LocalModifiedUnix: action.LastModifiedUnix,
+		LocalFiles:        action.Files,
+		LocalSize:         action.Size,
+		Format:            format,
+		Version:           c.Version,
+		Hostname:          c.Hostname,
+	}
+}
+
+func (c *Client) encryptionScheme() string {
+	if c.Encryption == nil {
+		return ""
+	}
+	return c.Encryption.Scheme()
+}
+
+func (c *Client) upload(ctx context.Context, root string, ch <-chan SyncAction) ([]UnitFailure, error) {
+	failures := []UnitFailure{}
+	for action := range ch {
 		if c.Dryrun {
 			continue
 		}
 
-		pr, pw := io.Pipe()
-		eg, ctx := errgroup.WithContext(ctx)
-		eg.Go(func() error {
-			defer pw.Close()
-			if err := c.Archiver.Do(ctx, filepath.Join(root, localObj.Key), pw); err != nil {
-				return fmt.Errorf("failed to archive %q: %w", localObj.Key, err)
+		err := c.retry(ctx, action.Unit, func() error {
+			return c.uploadUnit(ctx, root, action)
+		})
+		if err != nil {
+			if !c.KeepGoing || ctx.Err() != nil {
+				return nil, err
 			}
-			return nil
+			log.Printf("Failed to upload %s: %v", action.Unit, err)
+			failures = append(failures, UnitFailure{Unit: action.Unit, Err: err})
+			continue
+		}
+		if err := c.Journal.complete(action.Key); err != nil {
+			return nil, err
+		}
+	}
+	return failures, nil
+}
+
+// uploadUnit archives the unit of the action and uploads it.
+func (c *Client) uploadUnit(ctx context.Context, root string, action SyncAction) error {
+	pr, pw := io.Pipe()
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.Go(func() (err error) {
+		defer func() {
+			pw.CloseWithError(err)
+		}()
+		if err := c.archive(ctx, filepath.Join(root, action.Unit), pw); err != nil {
+			return fmt.Errorf("failed to archive %q: %w", action.Unit, err)
+		}
+		return nil
+	})
+	eg.Go(func() error {
+		// unblock the archiver if the repository returns without reading everything
+		defer pr.Close()
+		if err := c.uploadArchive(ctx, action.Key, pr, c.metadata(action)); err != nil {
+			return fmt.Errorf("failed to upload %q to repository: %w", action.Unit, err)
+		}
+		return nil
+	})
+	return eg.Wait()
+}
+
+// uploadArchive uploads the archive, resuming an interrupted upload if possible.
+func (c *Client) uploadArchive(ctx context.Context, key string, r io.Reader, meta Metadata) error {
+	if rr, ok := c.Repository.(ResumableRepository); ok && c.Journal != nil {
+		return rr.UploadResumable(ctx, key, r, meta, c.Journal)
+	}
+	return c.Repository.Upload(ctx, key, r, meta)
+}
+
+type ClientRestoreInput struct {
+	// Path is the directory which units are restored into.
+	Path string
+	// Keys selects units to restore. All units are restored if it is empty.
+	Keys []string
+	// Snapshot is the ID of the snapshot to restore units from, or "latest".
+	// Units are restored from archives outside of snapshots if it is empty.
+	Snapshot string
+}
+
+// unitArchive is an archive of the unit in the repository.
+type unitArchive struct {
+	Unit   string
+	Object RepositoryObject
+}
+
+func (c *Client) Restore(ctx context.Context, in *ClientRestoreInput) error {
+	repoObjects, err := c.Repository.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list objects from repository: %w", err)
+	}
+	archives, err := c.unitArchives(ctx, repoObjects, in.Snapshot)
+	if err != nil {
+		return err
+	}
+
+	queue := []unitArchive{}
+	if len(in.Keys) == 0 {
+		for unit, obj := range archives {
+			queue = append(queue, unitArchive{Unit: unit, Object: obj})
+		}
+		sort.Slice(queue, func(i, j int) bool {
+			return queue[i].Unit < queue[j].Unit
 		})
+	} else {
+		for _, k := range in.Keys {
+			unit := strings.Trim(k, "/")
+			obj, ok := archives[unit]
+			if !ok {
+				return fmt.Errorf("unit %q not found in repository", k)
+			}
+			queue = append(queue, unitArchive{Unit: unit, Object: obj})
+		}
+	}
+
+	eg, ctx := errgroup.WithContext(ctx)
+	ch := make(chan unitArchive, c.concurrency())
+
+	eg.Go(func() error {
+		defer close(ch)
+		for i, v := range queue {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("restoring cancelled: %w", ctx.Err())
+			case ch <- v:
+				log.Printf("Restoring(%d/%d): %s", i+1, len(queue), v.Object.Key)
+			}
+		}
+		return nil
+	})
+	for i := 0; i < c.concurrency(); i++ {
 		eg.Go(func() error {
-			if err := c.Repository.Upload(ctx, localObj.Key+".tar", pr); err != nil {
-				return fmt.Errorf("failed to upload %q to repository: %w", localObj.Key, err)
+			if err := c.restore(ctx, in.Path, ch); err != nil {
+				return fmt.Errorf("restoring failed: %w", err)
 			}
 			return nil
 		})
+	}
+	return eg.Wait()
+}
 
-		if err := eg.Wait(); err != nil {
+// unitArchives returns the archives of units in the snapshot, or outside of snapshots if snapshot is empty.
+func (c *Client) unitArchives(ctx context.Context, repoObjects []RepositoryObject, snapshot string) (map[string]RepositoryObject, error) {
+	if snapshot != "" {
+		return c.snapshotArchives(ctx, repoObjects, snapshot)
+	}
+	archives := map[string]RepositoryObject{}
+	for _, obj := range repoObjects {
+		unit, _, ok := parseArchiveKey(obj.Key)
+		if !ok || strings.HasPrefix(obj.Key, SnapshotPrefix) {
+			continue
+		}
+		// prefer the newest one if a unit has archives with different compressions
+		if prev, ok := archives[unit]; ok && prev.LastModifiedUnix >= obj.LastModifiedUnix {
+			continue
+		}
+		archives[unit] = obj
+	}
+	return archives, nil
+}
+
+func (c *Client) restore(ctx context.Context, root string, ch <-chan unitArchive) error {
+	for a := range ch {
+		dest, err := safeJoin(root, a.Unit)
+		if err != nil {
 			return err
 		}
+
+		if err := c.restoreOne(ctx, a.Object, dest); err != nil {
+			return fmt.Errorf("failed to restore %q: %w", a.Unit, err)
+		}
 	}
 	return nil
 }
+
+func (c *Client) restoreOne(ctx context.Context, obj RepositoryObject, dest string) error {
+	return c.download(ctx, obj, func(r io.Reader) error {
+		if err := c.Extractor.Do(ctx, r, dest); err != nil {
+			return fmt.Errorf("failed to extract: %w", err)
+		}
+		return nil
+	})
+}
+
+// download calls fn with the tar stream of the archive, and then verifies the archive with its SHA-256 digest if recorded.
+// fn may have consumed a corrupted archive when ErrChecksumMismatch is returned.
+func (c *Client) download(ctx context.Context, obj RepositoryObject, fn func(r io.Reader) error) error {
+	if mr, ok := c.Repository.(MetadataRepository); ok {
+		objs := []RepositoryObject{obj}
+		if err := mr.FillMetadata(ctx, objs); err != nil {
+			return fmt.Errorf("failed to get metadata from repository: %w", err)
+		}
+		obj = objs[0]
+	}
+	if dr, ok := c.Repository.(DigestRepository); ok && obj.Metadata.SHA256 == "" {
+		if err := dr.FillDigest(ctx, &obj); err != nil {
+			return fmt.Errorf("failed to get digest from repository: %w", err)
+		}
+	}
+	rc, err := c.Repository.Download(ctx, obj.Key)
+	if err != nil {
+		return fmt.Errorf("failed to download from repository: %w", err)
+	}
+	defer rc.Close()
+
+	dr := newDigestReader(rc)
+	r, err := c.unarchive(obj, dr)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if err := fn(r); err != nil {
+		return err
+	}
+	// the tar reader stops at the end marker, before padding and trailers of compression and encryption.
+	if _, err := io.Copy(io.Discard, dr); err != nil {
+		return fmt.Errorf("failed to download from repository: %w", err)
+	}
+	if err := dr.verify(obj.Metadata.SHA256); err != nil {
+		return fmt.Errorf("archive %q is corrupted: %w", obj.Key, err)
+	}
+	return nil
+}
+
+// unarchive returns the tar stream of the repository object read from r, decrypting and decompressing it.
+func (c *Client) unarchive(obj RepositoryObject, r io.Reader) (io.ReadCloser, error) {
+	_, comp, ok := parseArchiveKey(obj.Key)
+	if !ok {
+		return nil, fmt.Errorf("%q is not an archive", obj.Key)
+	}
+
+	if scheme := obj.Metadata.Encryption; scheme != "" {
+		if c.Encryption == nil {
+			return nil, fmt.Errorf("archive is encrypted with %q but no key is given", scheme)
+		}
+		if scheme != c.Encryption.Scheme() {
+			return nil, fmt.Errorf("unsupported encryption %q", scheme)
+		}
+		dr, err := c.Encryption.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start decryption: %w", err)
+		}
+		r = dr
+	}
+
+	cr, err := comp.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress: %w", err)
+	}
+	return cr, nil
+}
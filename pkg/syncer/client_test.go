@@ -2,13 +2,24 @@ package syncer_test
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/hareku/smart-syncer/pkg/syncer"
 	"github.com/hareku/smart-syncer/pkg/syncer/syncermock"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/mod/sumdb/dirhash"
 )
 
 func TestClient_Run(t *testing.T) {
@@ -29,8 +40,8 @@ func TestClient_Run(t *testing.T) {
 			LastModifiedUnix: 40,
 		},
 	}, nil)
-	repo.EXPECT().Upload(gomock.Any(), "obj1.tar", gomock.Any()).Times(1).Return(nil)
-	repo.EXPECT().Upload(gomock.Any(), "new/obj3.tar", gomock.Any()).Times(1).Return(nil)
+	repo.EXPECT().Upload(gomock.Any(), "obj1.tar", gomock.Any(), syncer.Metadata{LocalModifiedUnix: 10, Format: "tar"}).Times(1).Return(nil)
+	repo.EXPECT().Upload(gomock.Any(), "new/obj3.tar", gomock.Any(), syncer.Metadata{LocalModifiedUnix: 30, Format: "tar"}).Times(1).Return(nil)
 	repo.EXPECT().Delete(gomock.Any(), []string{"obj4.tar"}).Times(1).Return(nil)
 
 	local := syncermock.NewMockLocalStorage(ctrl)
@@ -66,3 +77,607 @@ func TestClient_Run(t *testing.T) {
 	})
 	require.NoError(t, err)
 }
+
+func TestClient_Run_Fingerprint(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	repo := syncermock.NewMockRepository(ctrl)
+	repo.EXPECT().List(gomock.Any()).Times(1).Return([]syncer.RepositoryObject{
+		{
+			Key:              "obj1.tar",
+			LastModifiedUnix: 100,
+			Metadata:         syncer.Metadata{Fingerprint: "h1:old"},
+		},
+		{
+			Key:              "obj2.tar",
+			LastModifiedUnix: 1,
+			Metadata:         syncer.Metadata{Fingerprint: "h1:obj2"},
+		},
+	}, nil)
+	repo.EXPECT().Upload(gomock.Any(), "obj1.tar", gomock.Any(), syncer.Metadata{Fingerprint: "h1:obj1", LocalModifiedUnix: 10, Format: "tar"}).Times(1).Return(nil)
+
+	local := syncermock.NewMockLocalStorage(ctrl)
+	local.EXPECT().List(gomock.Any(), "target", 1).Times(1).Return([]syncer.LocalObject{
+		{
+			Key:              "obj1",
+			LastModifiedUnix: 10,
+		},
+		{
+			Key:              "obj2",
+			LastModifiedUnix: 20,
+		},
+	}, nil)
+	local.EXPECT().Fingerprint(gomock.Any(), filepath.Join("target/obj1")).Times(1).Return("h1:obj1", nil)
+	local.EXPECT().Fingerprint(gomock.Any(), filepath.Join("target/obj2")).Times(1).Return("h1:obj2", nil)
+
+	arc := syncermock.NewMockArchiver(ctrl)
+	arc.EXPECT().Do(gomock.Any(), filepath.Join("target/obj1"), gomock.Any()).Times(1).Return(nil)
+
+	c := &syncer.Client{
+		LocalStorage: local,
+		Repository:   repo,
+		Archiver:     arc,
+		Concurrency:  2,
+		Fingerprint:  true,
+	}
+	err := c.Run(context.Background(), &syncer.ClientRunInput{
+		Path:  "target",
+		Depth: 1,
+	})
+	require.NoError(t, err)
+}
+
+func TestClient_Restore(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	repo := syncermock.NewMockRepository(ctrl)
+	repo.EXPECT().List(gomock.Any()).Times(1).Return([]syncer.RepositoryObject{
+		{Key: "obj1.tar"},
+		{Key: "new/obj2.tar"},
+		{Key: "not-archive"},
+	}, nil)
+	repo.EXPECT().Download(gomock.Any(), "obj1.tar").Times(1).Return(io.NopCloser(strings.NewReader("obj1")), nil)
+	repo.EXPECT().Download(gomock.Any(), "new/obj2.tar").Times(1).Return(io.NopCloser(strings.NewReader("obj2")), nil)
+
+	ext := syncermock.NewMockExtractor(ctrl)
+	ext.EXPECT().Do(gomock.Any(), gomock.Any(), filepath.Join("dest/obj1")).Times(1).Return(nil)
+	ext.EXPECT().Do(gomock.Any(), gomock.Any(), filepath.Join("dest/new/obj2")).Times(1).Return(nil)
+
+	c := &syncer.Client{
+		Repository:  repo,
+		Extractor:   ext,
+		Concurrency: 2,
+	}
+	require.NoError(t, c.Restore(context.Background(), &syncer.ClientRestoreInput{
+		Path: "dest",
+	}))
+
+	t.Run("missing key", func(t *testing.T) {
+		repo.EXPECT().List(gomock.Any()).Times(1).Return([]syncer.RepositoryObject{
+			{Key: "obj1.tar"},
+		}, nil)
+		err := c.Restore(context.Background(), &syncer.ClientRestoreInput{
+			Path: "dest",
+			Keys: []string{"obj2"},
+		})
+		require.Error(t, err)
+	})
+
+	t.Run("checksum", func(t *testing.T) {
+		digest := fmt.Sprintf("%x", sha256.Sum256([]byte("obj1")))
+		repo.EXPECT().List(gomock.Any()).Times(2).Return([]syncer.RepositoryObject{
+			{Key: "obj1.tar", Metadata: syncer.Metadata{SHA256: digest}},
+		}, nil)
+		// the extractor stops at the end of the archive, but the rest is verified too.
+		ext.EXPECT().Do(gomock.Any(), gomock.Any(), filepath.Join("dest/obj1")).Times(2).
+			DoAndReturn(func(ctx context.Context, r io.Reader, dest string) error {
+				_, err := io.ReadFull(r, make([]byte, 2))
+				return err
+			})
+
+		repo.EXPECT().Download(gomock.Any(), "obj1.tar").Times(1).Return(io.NopCloser(strings.NewReader("obj1")), nil)
+		require.NoError(t, c.Restore(context.Background(), &syncer.ClientRestoreInput{Path: "dest"}))
+
+		repo.EXPECT().Download(gomock.Any(), "obj1.tar").Times(1).Return(io.NopCloser(strings.NewReader("obj2")), nil)
+		err := c.Restore(context.Background(), &syncer.ClientRestoreInput{Path: "dest"})
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, syncer.ErrChecksumMismatch), err)
+	})
+
+	t.Run("digest stored aside", func(t *testing.T) {
+		repo := syncermock.NewMockDigestRepository(ctrl)
+		repo.EXPECT().List(gomock.Any()).Times(1).Return([]syncer.RepositoryObject{
+			{Key: "obj1.tar"},
+		}, nil)
+		repo.EXPECT().FillDigest(gomock.Any(), &syncer.RepositoryObject{Key: "obj1.tar"}).Times(1).
+			DoAndReturn(func(ctx context.Context, obj *syncer.RepositoryObject) error {
+				obj.Metadata.SHA256 = fmt.Sprintf("%x", sha256.Sum256([]byte("obj1")))
+				return nil
+			})
+		repo.EXPECT().Download(gomock.Any(), "obj1.tar").Times(1).Return(io.NopCloser(strings.NewReader("obj2")), nil)
+		ext.EXPECT().Do(gomock.Any(), gomock.Any(), filepath.Join("dest/obj1")).Times(1).Return(nil)
+
+		c := &syncer.Client{Repository: repo, Extractor: ext}
+		err := c.Restore(context.Background(), &syncer.ClientRestoreInput{Path: "dest"})
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, syncer.ErrChecksumMismatch), err)
+	})
+}
+
+func TestClient_Run_Compression(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	repo := syncermock.NewMockRepository(ctrl)
+	repo.EXPECT().List(gomock.Any()).Times(1).Return([]syncer.RepositoryObject{
+		{
+			Key:              "obj1.tar",
+			LastModifiedUnix: 100,
+		},
+		{
+			Key:              "obj2.tar.gz",
+			LastModifiedUnix: 100,
+		},
+		{
+			Key:              "obj2.tar.zst",
+			LastModifiedUnix: 100,
+		},
+		{
+			Key:              "obj3.tar.zst",
+			LastModifiedUnix: 100,
+		},
+		{
+			Key:              "not-archive",
+			LastModifiedUnix: 100,
+		},
+	}, nil)
+	repo.EXPECT().Upload(gomock.Any(), "obj1.tar.gz", gomock.Any(), syncer.Metadata{LocalModifiedUnix: 10, Format: "tar+gzip"}).Times(1).
+		DoAndReturn(func(_ context.Context, _ string, r io.Reader, _ syncer.Metadata) error {
+			_, err := io.Copy(io.Discard, r)
+			return err
+		})
+	repo.EXPECT().Delete(gomock.Any(), gomock.InAnyOrder([]string{"obj1.tar", "obj2.tar.zst", "obj3.tar.zst"})).Times(1).Return(nil)
+
+	local := syncermock.NewMockLocalStorage(ctrl)
+	local.EXPECT().List(gomock.Any(), "target", 1).Times(1).Return([]syncer.LocalObject{
+		{
+			Key:              "obj1",
+			LastModifiedUnix: 10,
+		},
+		{
+			Key:              "obj2",
+			LastModifiedUnix: 10,
+		},
+	}, nil)
+
+	arc := syncermock.NewMockArchiver(ctrl)
+	arc.EXPECT().Do(gomock.Any(), filepath.Join("target/obj1"), gomock.Any()).Times(1).Return(nil)
+
+	comp, err := syncer.NewCompression("gzip")
+	require.NoError(t, err)
+	c := &syncer.Client{
+		LocalStorage: local,
+		Repository:   repo,
+		Archiver:     arc,
+		Concurrency:  1,
+		Compression:  comp,
+	}
+	err = c.Run(context.Background(), &syncer.ClientRunInput{
+		Path:  "target",
+		Depth: 1,
+	})
+	require.NoError(t, err)
+}
+
+func TestClient_Run_Encryption(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	enc := syncer.NewAESGCMEncryption([]byte("secret"))
+
+	repo := syncermock.NewMockRepository(ctrl)
+	repo.EXPECT().List(gomock.Any()).Times(1).Return([]syncer.RepositoryObject{
+		{
+			Key:              "obj1.tar",
+			LastModifiedUnix: 100,
+		},
+		{
+			Key:              "obj2.tar",
+			LastModifiedUnix: 100,
+			Metadata:         syncer.Metadata{Encryption: enc.Scheme()},
+		},
+	}, nil)
+	repo.EXPECT().Upload(gomock.Any(), "obj1.tar", gomock.Any(), syncer.Metadata{Encryption: enc.Scheme(), LocalModifiedUnix: 10, Format: "tar"}).Times(1).
+		DoAndReturn(func(_ context.Context, _ string, r io.Reader, _ syncer.Metadata) error {
+			_, err := io.Copy(io.Discard, r)
+			return err
+		})
+
+	local := syncermock.NewMockLocalStorage(ctrl)
+	local.EXPECT().List(gomock.Any(), "target", 1).Times(1).Return([]syncer.LocalObject{
+		{
+			Key:              "obj1",
+			LastModifiedUnix: 10,
+		},
+		{
+			Key:              "obj2",
+			LastModifiedUnix: 10,
+		},
+	}, nil)
+
+	arc := syncermock.NewMockArchiver(ctrl)
+	arc.EXPECT().Do(gomock.Any(), filepath.Join("target/obj1"), gomock.Any()).Times(1).Return(nil)
+
+	c := &syncer.Client{
+		LocalStorage: local,
+		Repository:   repo,
+		Archiver:     arc,
+		Concurrency:  1,
+		Encryption:   enc,
+	}
+	err := c.Run(context.Background(), &syncer.ClientRunInput{
+		Path:  "target",
+		Depth: 1,
+	})
+	require.NoError(t, err)
+}
+
+func TestClient_Run_Concurrency(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	const n = 3
+
+	repo := syncermock.NewMockRepository(ctrl)
+	repo.EXPECT().List(gomock.Any()).Times(1).Return([]syncer.RepositoryObject{}, nil)
+	repo.EXPECT().Upload(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(n).
+		DoAndReturn(func(_ context.Context, _ string, r io.Reader, _ syncer.Metadata) error {
+			_, err := io.Copy(io.Discard, r)
+			return err
+		})
+
+	localObjects := make([]syncer.LocalObject, n)
+	for i := range localObjects {
+		localObjects[i] = syncer.LocalObject{Key: fmt.Sprintf("obj%d", i)}
+	}
+	local := syncermock.NewMockLocalStorage(ctrl)
+	local.EXPECT().List(gomock.Any(), "target", 1).Times(1).Return(localObjects, nil)
+
+	// every archiving waits for the others to start, so it succeeds only if units are processed in parallel.
+	started := sync.WaitGroup{}
+	started.Add(n)
+	arc := syncermock.NewMockArchiver(ctrl)
+	arc.EXPECT().Do(gomock.Any(), gomock.Any(), gomock.Any()).Times(n).
+		DoAndReturn(func(context.Context, string, io.Writer) error {
+			started.Done()
+			done := make(chan struct{})
+			go func() {
+				started.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+				return nil
+			case <-time.After(5 * time.Second):
+				return errors.New("units are not processed in parallel")
+			}
+		})
+
+	c := &syncer.Client{
+		LocalStorage: local,
+		Repository:   repo,
+		Archiver:     arc,
+		Concurrency:  n,
+	}
+	err := c.Run(context.Background(), &syncer.ClientRunInput{
+		Path:  "target",
+		Depth: 1,
+	})
+	require.NoError(t, err)
+}
+
+func TestClient_EndToEnd(t *testing.T) {
+	srcDir, err := os.MkdirTemp("", "client-e2e-test-src-")
+	require.NoError(t, err)
+	repoDir, err := os.MkdirTemp("", "client-e2e-test-repo-")
+	require.NoError(t, err)
+	destDir, err := os.MkdirTemp("", "client-e2e-test-dest-")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, os.RemoveAll(srcDir))
+		assert.NoError(t, os.RemoveAll(repoDir))
+		assert.NoError(t, os.RemoveAll(destDir))
+	})
+
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "abc"), []byte("data for abc"), 0777))
+	require.NoError(t, os.MkdirAll(filepath.Join(srcDir, "def/ghi"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "def/ghi/jkl"), []byte("data for jkl"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "def/mno"), []byte("data for mno"), 0777))
+
+	comp, err := syncer.NewCompression("zstd")
+	require.NoError(t, err)
+	c := &syncer.Client{
+		LocalStorage: syncer.NewLocalStorage(&syncer.NewLocalStorageInput{}),
+		Repository:   syncer.NewRepositoryFS(repoDir),
+		Archiver:     syncer.NewArchiver(&syncer.NewArchiverInput{}),
+		Extractor:    syncer.NewExtractor(&syncer.NewExtractorInput{}),
+		Concurrency:  2,
+		Fingerprint:  true,
+		Compression:  comp,
+		Encryption:   syncer.NewAESGCMEncryption([]byte("secret")),
+	}
+	ctx := context.Background()
+	require.NoError(t, c.Run(ctx, &syncer.ClientRunInput{
+		Path:  srcDir,
+		Depth: 1,
+	}))
+	require.NoError(t, c.Restore(ctx, &syncer.ClientRestoreInput{
+		Path: destDir,
+	}))
+
+	expected, err := dirhash.HashDir(srcDir, "", dirhash.Hash1)
+	require.NoError(t, err)
+	got, err := dirhash.HashDir(destDir, "", dirhash.Hash1)
+	require.NoError(t, err)
+	assert.Equal(t, expected, got)
+}
+
+func TestClient_Run_MaxDelete(t *testing.T) {
+	tests := []struct {
+		name             string
+		maxDelete        int
+		maxDeletePercent float64
+		wantErr          bool
+	}{
+		{name: "unlimited"},
+		{name: "under count", maxDelete: 2},
+		{name: "over count", maxDelete: 1, wantErr: true},
+		{name: "under percent", maxDeletePercent: 50},
+		{name: "over percent", maxDeletePercent: 49, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+
+			repo := syncermock.NewMockRepository(ctrl)
+			repo.EXPECT().List(gomock.Any()).Return([]syncer.RepositoryObject{
+				{Key: "obj1.tar", LastModifiedUnix: 10},
+				{Key: "obj2.tar", LastModifiedUnix: 10},
+				{Key: "obj3.tar", LastModifiedUnix: 10},
+				{Key: "obj4.tar", LastModifiedUnix: 10},
+			}, nil)
+			if !tt.wantErr {
+				repo.EXPECT().Delete(gomock.Any(), gomock.InAnyOrder([]string{"obj3.tar", "obj4.tar"})).Return(nil)
+			}
+
+			local := syncermock.NewMockLocalStorage(ctrl)
+			local.EXPECT().List(gomock.Any(), "target", 1).Return([]syncer.LocalObject{
+				{Key: "obj1", LastModifiedUnix: 10},
+				{Key: "obj2", LastModifiedUnix: 10},
+			}, nil)
+
+			c := &syncer.Client{
+				LocalStorage:     local,
+				Repository:       repo,
+				Archiver:         syncermock.NewMockArchiver(ctrl),
+				MaxDelete:        tt.maxDelete,
+				MaxDeletePercent: tt.maxDeletePercent,
+			}
+			err := c.Run(context.Background(), &syncer.ClientRunInput{
+				Path:  "target",
+				Depth: 1,
+			})
+			if !tt.wantErr {
+				require.NoError(t, err)
+				return
+			}
+			var tooMany *syncer.TooManyDeletesError
+			require.True(t, errors.As(err, &tooMany))
+			assert.Equal(t, 2, tooMany.Deletes)
+			assert.Equal(t, 4, tooMany.Total)
+		})
+	}
+}
+
+func TestClient_Run_SoftDelete(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	repo := syncermock.NewMockTrashRepository(ctrl)
+	repo.EXPECT().List(gomock.Any()).Return([]syncer.RepositoryObject{
+		{Key: "obj1.tar", LastModifiedUnix: 10},
+		{Key: "obj2.tar", LastModifiedUnix: 10},
+	}, nil)
+	repo.EXPECT().Trash(gomock.Any(), []string{"obj2.tar"}, gomock.Any()).Return(nil)
+
+	local := syncermock.NewMockLocalStorage(ctrl)
+	local.EXPECT().List(gomock.Any(), "target", 1).Return([]syncer.LocalObject{
+		{Key: "obj1", LastModifiedUnix: 10},
+	}, nil)
+
+	c := &syncer.Client{
+		LocalStorage: local,
+		Repository:   repo,
+		Archiver:     syncermock.NewMockArchiver(ctrl),
+		SoftDelete:   true,
+	}
+	require.NoError(t, c.Run(context.Background(), &syncer.ClientRunInput{
+		Path:  "target",
+		Depth: 1,
+	}))
+
+	t.Run("unsupported repository", func(t *testing.T) {
+		c := &syncer.Client{
+			LocalStorage: syncermock.NewMockLocalStorage(ctrl),
+			Repository:   syncermock.NewMockRepository(ctrl),
+			Archiver:     syncermock.NewMockArchiver(ctrl),
+			SoftDelete:   true,
+		}
+		require.Error(t, c.Run(context.Background(), &syncer.ClientRunInput{Path: "target", Depth: 1}))
+	})
+}
+
+func TestClient_Run_SourceCheck(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	dir, err := os.MkdirTemp("", "client-test-")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, os.RemoveAll(dir))
+	})
+
+	repo := syncermock.NewMockRepository(ctrl)
+	repo.EXPECT().List(gomock.Any()).Return([]syncer.RepositoryObject{
+		{Key: "obj1.tar", LastModifiedUnix: 10},
+	}, nil)
+
+	c := &syncer.Client{
+		LocalStorage: syncer.NewLocalStorage(&syncer.NewLocalStorageInput{}),
+		Repository:   repo,
+		Archiver:     syncermock.NewMockArchiver(ctrl),
+		SourceCheck:  &syncer.SourceCheck{MinObjects: 1},
+	}
+	err = c.Run(context.Background(), &syncer.ClientRunInput{
+		Path:  dir,
+		Depth: 1,
+	})
+	var srcErr *syncer.SourceError
+	require.True(t, errors.As(err, &srcErr), err)
+
+	t.Run("empty source", func(t *testing.T) {
+		repo.EXPECT().List(gomock.Any()).Times(2).Return([]syncer.RepositoryObject{
+			{Key: "obj1.tar", LastModifiedUnix: 10},
+		}, nil)
+
+		// an empty source is refused without any check configured.
+		c := &syncer.Client{
+			LocalStorage: syncer.NewLocalStorage(&syncer.NewLocalStorageInput{}),
+			Repository:   repo,
+		}
+		_, err := c.Plan(context.Background(), &syncer.ClientRunInput{Path: dir, Depth: 1})
+		var srcErr *syncer.SourceError
+		require.True(t, errors.As(err, &srcErr), err)
+
+		c.AllowEmpty = true
+		plan, err := c.Plan(context.Background(), &syncer.ClientRunInput{Path: dir, Depth: 1})
+		require.NoError(t, err)
+		assert.Equal(t, 1, plan.Count(syncer.SyncActionDelete))
+	})
+
+	t.Run("apply", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, ".marker"), nil, 0666))
+		repo.EXPECT().List(gomock.Any()).Times(1).Return([]syncer.RepositoryObject{
+			{Key: "obj1.tar", LastModifiedUnix: 10},
+		}, nil)
+		c := &syncer.Client{
+			LocalStorage: syncer.NewLocalStorage(&syncer.NewLocalStorageInput{}),
+			Repository:   repo,
+			SourceCheck:  &syncer.SourceCheck{MarkerFile: ".marker"},
+			AllowEmpty:   true,
+		}
+		plan, err := c.Plan(context.Background(), &syncer.ClientRunInput{Path: dir, Depth: 1})
+		require.NoError(t, err)
+		b, err := json.Marshal(plan)
+		require.NoError(t, err)
+
+		// the plan is applied by a client without the check, after the source was unmounted.
+		require.NoError(t, os.Remove(filepath.Join(dir, ".marker")))
+		stored := &syncer.SyncPlan{}
+		require.NoError(t, json.Unmarshal(b, stored))
+		c = &syncer.Client{Repository: repo}
+		err = c.Apply(context.Background(), stored)
+		var srcErr *syncer.SourceError
+		require.True(t, errors.As(err, &srcErr), err)
+	})
+}
+
+func TestClient_Apply_GracePeriod(t *testing.T) {
+	plan := &syncer.SyncPlan{
+		Path:        "target",
+		Compression: "none",
+		Actions: []syncer.SyncAction{
+			{Type: syncer.SyncActionUploadNew, Unit: "obj1", Key: "obj1.tar"},
+			{Type: syncer.SyncActionUploadNew, Unit: "obj2", Key: "obj2.tar"},
+			{Type: syncer.SyncActionDelete, Unit: "obj3", Key: "obj3.tar"},
+		},
+	}
+
+	for _, tt := range []struct {
+		grace    time.Duration
+		uploaded bool
+	}{
+		{grace: time.Minute, uploaded: true},
+		{grace: 0, uploaded: false},
+	} {
+		t.Run(fmt.Sprintf("grace period: %v", tt.grace), func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// the upload in flight finishes only within the grace period, and the next one and deletion are not started.
+			uploaded := false
+			repo := syncermock.NewMockRepository(ctrl)
+			repo.EXPECT().Upload(gomock.Any(), "obj1.tar", gomock.Any(), gomock.Any()).Times(1).
+				DoAndReturn(func(uploadCtx context.Context, key string, r io.Reader, meta syncer.Metadata) error {
+					cancel()
+					select {
+					case <-uploadCtx.Done():
+						return uploadCtx.Err()
+					case <-time.After(50 * time.Millisecond):
+						uploaded = true
+						return nil
+					}
+				})
+
+			arc := syncermock.NewMockArchiver(ctrl)
+			arc.EXPECT().Do(gomock.Any(), filepath.Join("target/obj1"), gomock.Any()).Times(1).Return(nil)
+
+			c := &syncer.Client{
+				Repository:  repo,
+				Archiver:    arc,
+				Concurrency: 1,
+				GracePeriod: tt.grace,
+			}
+			err := c.Apply(ctx, plan)
+			assert.True(t, errors.Is(err, context.Canceled), err)
+			assert.Equal(t, tt.uploaded, uploaded)
+		})
+	}
+}
+
+func TestClient_Apply_KeepGoing(t *testing.T) {
+	plan := &syncer.SyncPlan{
+		Path:            "target",
+		Compression:     "none",
+		RepositoryUnits: 3,
+		Actions: []syncer.SyncAction{
+			{Type: syncer.SyncActionUploadChanged, Unit: "obj1", Key: "obj1.tar"},
+			{Type: syncer.SyncActionUploadNew, Unit: "obj2", Key: "obj2.tar"},
+			{Type: syncer.SyncActionDelete, Unit: "obj3", Key: "obj3.tar"},
+			{Type: syncer.SyncActionDelete, Unit: "obj1", Key: "obj1.tar.gz"},
+		},
+	}
+
+	ctrl := gomock.NewController(t)
+	repo := syncermock.NewMockRepository(ctrl)
+	repo.EXPECT().Upload(gomock.Any(), "obj1.tar", gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, key string, r io.Reader, meta syncer.Metadata) error {
+			_, err := io.ReadAll(r)
+			return err
+		})
+	repo.EXPECT().Upload(gomock.Any(), "obj2.tar", gomock.Any(), gomock.Any()).Return(nil)
+	// the old archive of obj1 is kept because its new one is not uploaded.
+	repo.EXPECT().Delete(gomock.Any(), []string{"obj3.tar"}).Return(nil)
+
+	arc := syncermock.NewMockArchiver(ctrl)
+	arc.EXPECT().Do(gomock.Any(), filepath.Join("target/obj1"), gomock.Any()).Return(errors.New("permission denied"))
+	arc.EXPECT().Do(gomock.Any(), filepath.Join("target/obj2"), gomock.Any()).Return(nil)
+
+	c := &syncer.Client{
+		Repository:  repo,
+		Archiver:    arc,
+		Concurrency: 1,
+		KeepGoing:   true,
+	}
+	err := c.Apply(context.Background(), plan)
+	var failedErr *syncer.UnitsFailedError
+	require.True(t, errors.As(err, &failedErr), err)
+	assert.Equal(t, 2, failedErr.Total)
+	require.Len(t, failedErr.Failures, 1)
+	assert.Equal(t, "obj1", failedErr.Failures[0].Unit)
+	assert.Contains(t, failedErr.Failures[0].Err.Error(), "permission denied")
+}
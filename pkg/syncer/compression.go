@@ -0,0 +1,127 @@
+package syncer
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is a streaming codec applied to archives.
+type Compression interface {
+	// Name identifies the compression, such as "gzip".
+	Name() string
+	// Extension is appended to the ".tar" extension of archive keys, such as ".gz".
+	Extension() string
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// compressions are all supported compressions.
+var compressions = []Compression{
+	&noCompression{},
+	&gzipCompression{},
+	&zstdCompression{},
+}
+
+// NewCompression returns the compression of the name, one of "none", "gzip" and "zstd".
+func NewCompression(name string) (Compression, error) {
+	for _, c := range compressions {
+		if c.Name() == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown compression %q", name)
+}
+
+// archiveKey returns the repository key of the unit archived with comp.
+func archiveKey(unit string, comp Compression) string {
+	return unit + ".tar" + comp.Extension()
+}
+
+// parseArchiveKey splits the repository key of an archive into the unit key and its compression.
+// It returns false if the key is not an archive.
+func parseArchiveKey(key string) (string, Compression, bool) {
+	for _, c := range compressions {
+		if ext := ".tar" + c.Extension(); strings.HasSuffix(key, ext) {
+			return strings.TrimSuffix(key, ext), c, true
+		}
+	}
+	return "", nil, false
+}
+
+type noCompression struct{}
+
+func (c *noCompression) Name() string {
+	return "none"
+}
+
+func (c *noCompression) Extension() string {
+	return ""
+}
+
+func (c *noCompression) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return nopWriteCloser{w}, nil
+}
+
+func (c *noCompression) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(r), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+type gzipCompression struct{}
+
+func (c *gzipCompression) Name() string {
+	return "gzip"
+}
+
+func (c *gzipCompression) Extension() string {
+	return ".gz"
+}
+
+func (c *gzipCompression) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (c *gzipCompression) NewReader(r io.Reader) (io.ReadCloser, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gzip header: %w", err)
+	}
+	return gr, nil
+}
+
+type zstdCompression struct{}
+
+func (c *zstdCompression) Name() string {
+	return "zstd"
+}
+
+func (c *zstdCompression) Extension() string {
+	return ".zst"
+}
+
+func (c *zstdCompression) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd writer: %w", err)
+	}
+	return zw, nil
+}
+
+func (c *zstdCompression) NewReader(r io.Reader) (io.ReadCloser, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd reader: %w", err)
+	}
+	return zr.IOReadCloser(), nil
+}
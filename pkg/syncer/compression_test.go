@@ -0,0 +1,45 @@
+package syncer_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/hareku/smart-syncer/pkg/syncer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompression(t *testing.T) {
+	data := strings.Repeat("data for compression ", 1000)
+
+	for _, name := range []string{"none", "gzip", "zstd"} {
+		t.Run(name, func(t *testing.T) {
+			c, err := syncer.NewCompression(name)
+			require.NoError(t, err)
+
+			buf := &bytes.Buffer{}
+			w, err := c.NewWriter(buf)
+			require.NoError(t, err)
+			_, err = io.WriteString(w, data)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+			if name != "none" {
+				assert.Less(t, buf.Len(), len(data))
+			}
+
+			r, err := c.NewReader(buf)
+			require.NoError(t, err)
+			got, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.NoError(t, r.Close())
+			assert.Equal(t, data, string(got))
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := syncer.NewCompression("unknown")
+		assert.Error(t, err)
+	})
+}
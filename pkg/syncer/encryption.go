@@ -0,0 +1,302 @@
+package syncer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encryption encrypts archives before uploading.
+type Encryption interface {
+	// Scheme identifies the encryption, and is recorded in object metadata.
+	Scheme() string
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.Reader, error)
+}
+
+// ErrDecryption is returned when an encrypted stream cannot be authenticated,
+// because of a wrong key or a corrupted or truncated stream.
+var ErrDecryption = errors.New("decryption failed")
+
+const (
+	aesGCMScheme    = "aes-256-gcm-stream-v1"
+	aesGCMChunkSize = 64 * 1024
+	aesGCMSaltSize  = 32
+)
+
+var aesGCMMagic = []byte("SSAE\x01")
+
+// NewAESGCMEncryption returns an encryption which uses AES-256-GCM in chunks, so that streams are not buffered.
+// Each stream is encrypted with its own key derived from secret and a random salt.
+// The secret should have high entropy, such as random bytes of a key file.
+func NewAESGCMEncryption(secret []byte) Encryption {
+	return &aesGCMEncryption{
+		secret: secret,
+	}
+}
+
+// NewPassphraseEncryption returns an AES-256-GCM encryption with a secret derived from the passphrase by scrypt.
+// The secret is derived with a random salt, which is written to the header of each stream,
+// so that reading a stream derives the secret again with its salt.
+func NewPassphraseEncryption(passphrase string) (Encryption, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("empty passphrase")
+	}
+	salt := make([]byte, passphraseSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	e := &passphraseEncryption{
+		passphrase: []byte(passphrase),
+		salt:       salt,
+		keys:       map[string]Encryption{},
+	}
+	// derive the secret of writers up front, which also validates the parameters.
+	if _, err := e.encryption(salt); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+const passphraseSaltSize = 16
+
+var passphraseMagic = []byte("SSAP\x01")
+
+// passphraseEncryption writes a stream in the following format:
+//
+//	magic || scrypt salt || aesGCMEncryption stream
+type passphraseEncryption struct {
+	passphrase []byte
+	salt       []byte
+
+	mu sync.Mutex
+	// keys caches encryptions by scrypt salts, because deriving a secret takes tens of milliseconds.
+	keys map[string]Encryption
+}
+
+func (e *passphraseEncryption) Scheme() string {
+	return aesGCMScheme
+}
+
+// encryption returns the encryption with the secret derived from the passphrase and salt.
+func (e *passphraseEncryption) encryption(salt []byte) (Encryption, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if enc, ok := e.keys[string(salt)]; ok {
+		return enc, nil
+	}
+	secret, err := scrypt.Key(e.passphrase, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key from passphrase: %w", err)
+	}
+	enc := NewAESGCMEncryption(secret)
+	e.keys[string(salt)] = enc
+	return enc, nil
+}
+
+func (e *passphraseEncryption) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	enc, err := e.encryption(e.salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(append([]byte{}, passphraseMagic...), e.salt...)); err != nil {
+		return nil, fmt.Errorf("failed to write header: %w", err)
+	}
+	return enc.NewWriter(w)
+}
+
+func (e *passphraseEncryption) NewReader(r io.Reader) (io.Reader, error) {
+	magic := make([]byte, len(passphraseMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	if !bytes.Equal(magic, passphraseMagic) {
+		return nil, fmt.Errorf("%w: unknown header", ErrDecryption)
+	}
+	salt := make([]byte, passphraseSaltSize)
+	if _, err := io.ReadFull(r, salt); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	enc, err := e.encryption(salt)
+	if err != nil {
+		return nil, err
+	}
+	return enc.NewReader(r)
+}
+
+// aesGCMEncryption writes a stream in the following format:
+//
+//	magic || salt || chunk...
+//
+// Every chunk has up to aesGCMChunkSize bytes of plaintext, and its nonce consists of the chunk counter
+// and a flag for the last chunk, so that reordered, dropped or truncated chunks are detected.
+type aesGCMEncryption struct {
+	secret []byte
+}
+
+func (e *aesGCMEncryption) Scheme() string {
+	return aesGCMScheme
+}
+
+func (e *aesGCMEncryption) aead(salt []byte) (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, e.secret, salt, []byte(aesGCMScheme)), key); err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gcm: %w", err)
+	}
+	return aead, nil
+}
+
+func (e *aesGCMEncryption) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	salt := make([]byte, aesGCMSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	aead, err := e.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(append([]byte{}, aesGCMMagic...), salt...)); err != nil {
+		return nil, fmt.Errorf("failed to write header: %w", err)
+	}
+	return &aesGCMWriter{
+		w:    w,
+		aead: aead,
+		buf:  make([]byte, 0, aesGCMChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (e *aesGCMEncryption) NewReader(r io.Reader) (io.Reader, error) {
+	header := make([]byte, len(aesGCMMagic)+aesGCMSaltSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	if !bytes.Equal(header[:len(aesGCMMagic)], aesGCMMagic) {
+		return nil, fmt.Errorf("%w: unknown header", ErrDecryption)
+	}
+	aead, err := e.aead(header[len(aesGCMMagic):])
+	if err != nil {
+		return nil, err
+	}
+	return &aesGCMReader{
+		r:    bufio.NewReader(r),
+		aead: aead,
+		buf:  make([]byte, aesGCMChunkSize+aead.Overhead()),
+	}, nil
+}
+
+// aesGCMNonce returns the nonce of the n-th chunk.
+func aesGCMNonce(n uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], n)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+type aesGCMWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buf     []byte // plaintext of the current chunk
+	counter uint64
+}
+
+func (w *aesGCMWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		// the full chunk is flushed only when more data comes, because the last chunk must be flagged.
+		if len(w.buf) == aesGCMChunkSize {
+			if err := w.flush(false); err != nil {
+				return n, err
+			}
+		}
+		m := copy(w.buf[len(w.buf):aesGCMChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+func (w *aesGCMWriter) flush(last bool) error {
+	sealed := w.aead.Seal(w.buf[:0], aesGCMNonce(w.counter, last), w.buf, nil)
+	if _, err := w.w.Write(sealed); err != nil {
+		return fmt.Errorf("failed to write encrypted chunk: %w", err)
+	}
+	w.counter++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Close writes the last chunk. It does not close the underlying writer.
+func (w *aesGCMWriter) Close() error {
+	return w.flush(true)
+}
+
+type aesGCMReader struct {
+	r         *bufio.Reader
+	aead      cipher.AEAD
+	buf       []byte
+	plaintext []byte // decrypted but not read yet
+	counter   uint64
+	done      bool
+}
+
+func (r *aesGCMReader) Read(p []byte) (int, error) {
+	for len(r.plaintext) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plaintext)
+	r.plaintext = r.plaintext[n:]
+	return n, nil
+}
+
+// next reads and decrypts the next chunk.
+func (r *aesGCMReader) next() error {
+	n, err := io.ReadFull(r.r, r.buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to read encrypted chunk: %w", err)
+	}
+	last := n < len(r.buf)
+	if !last {
+		_, err := r.r.Peek(1)
+		if errors.Is(err, io.EOF) {
+			last = true
+		} else if err != nil {
+			return fmt.Errorf("failed to read encrypted chunk: %w", err)
+		}
+	}
+
+	plaintext, err := r.aead.Open(r.buf[:0], aesGCMNonce(r.counter, last), r.buf[:n], nil)
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d", ErrDecryption, r.counter)
+	}
+	r.counter++
+	r.plaintext = plaintext
+	r.done = last
+	return nil
+}
@@ -0,0 +1,104 @@
+package syncer_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/hareku/smart-syncer/pkg/syncer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encrypt(t *testing.T, e syncer.Encryption, data []byte) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	w, err := e.NewWriter(buf)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestAESGCMEncryption(t *testing.T) {
+	e := syncer.NewAESGCMEncryption([]byte("secret"))
+
+	for _, size := range []int{0, 1, 64 * 1024, 64*1024 + 1, 3 * 64 * 1024} {
+		t.Run(fmt.Sprintf("size %d", size), func(t *testing.T) {
+			data := make([]byte, size)
+			_, err := rand.Read(data)
+			require.NoError(t, err)
+
+			encrypted := encrypt(t, e, data)
+			if size >= 64 {
+				assert.False(t, bytes.Contains(encrypted, data))
+			}
+
+			r, err := e.NewReader(bytes.NewReader(encrypted))
+			require.NoError(t, err)
+			got, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, data, got)
+		})
+	}
+
+	t.Run("random salt", func(t *testing.T) {
+		assert.NotEqual(t, encrypt(t, e, []byte("data")), encrypt(t, e, []byte("data")))
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		encrypted := encrypt(t, e, []byte("data"))
+		r, err := syncer.NewAESGCMEncryption([]byte("wrong")).NewReader(bytes.NewReader(encrypted))
+		require.NoError(t, err)
+		_, err = io.ReadAll(r)
+		assert.True(t, errors.Is(err, syncer.ErrDecryption), err)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		data := make([]byte, 2*64*1024+10)
+		encrypted := encrypt(t, e, data)
+		// drop the last chunk
+		encrypted = encrypted[:len(encrypted)-(10+16)]
+		r, err := e.NewReader(bytes.NewReader(encrypted))
+		require.NoError(t, err)
+		_, err = io.ReadAll(r)
+		assert.True(t, errors.Is(err, syncer.ErrDecryption), err)
+	})
+}
+
+func TestPassphraseEncryption(t *testing.T) {
+	e1, err := syncer.NewPassphraseEncryption("passphrase")
+	require.NoError(t, err)
+	e2, err := syncer.NewPassphraseEncryption("passphrase")
+	require.NoError(t, err)
+
+	// e2 has another salt, and derives the key with the salt stored in the stream.
+	encrypted := encrypt(t, e1, []byte("data"))
+	assert.NotEqual(t, encrypted[:21], encrypt(t, e2, []byte("data"))[:21])
+	r, err := e2.NewReader(bytes.NewReader(encrypted))
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(got))
+
+	t.Run("wrong passphrase", func(t *testing.T) {
+		e, err := syncer.NewPassphraseEncryption("wrong")
+		require.NoError(t, err)
+		r, err := e.NewReader(bytes.NewReader(encrypted))
+		require.NoError(t, err)
+		_, err = io.ReadAll(r)
+		assert.True(t, errors.Is(err, syncer.ErrDecryption), err)
+	})
+
+	t.Run("stream without salt", func(t *testing.T) {
+		_, err := e1.NewReader(bytes.NewReader(encrypt(t, syncer.NewAESGCMEncryption(make([]byte, 32)), []byte("data"))))
+		assert.True(t, errors.Is(err, syncer.ErrDecryption), err)
+	})
+
+	_, err = syncer.NewPassphraseEncryption("")
+	assert.Error(t, err)
+}
@@ -0,0 +1,329 @@
+package syncer
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//go:generate mockgen -destination ./${GOPACKAGE}mock/${GOFILE} -package ${GOPACKAGE}mock -source ./${GOFILE}
+
+// ErrUnsafePath is returned when an archive contains a path which escapes the destination.
+var ErrUnsafePath = errors.New("unsafe path")
+
+// rootEntryName is the name of the entry of the root directory, which Archiver writes first.
+const rootEntryName = "./"
+
+// Extractor is the counterpart of Archiver.
+type Extractor interface {
+	// Do extracts the tar archive read from r to dest.
+	// An archive made from a directory, which starts with the root entry "./", is extracted into the directory dest,
+	// and an archive made from a single file is extracted to dest itself.
+	Do(ctx context.Context, r io.Reader, dest string) error
+}
+
+type NewExtractorInput struct {
+	// PreserveOwner restores the owner and group of files recorded by NewArchiverInput.PreserveOwner.
+	// Changing them is silently skipped without permission.
+	PreserveOwner bool
+	// Xattrs restores extended attributes recorded by NewArchiverInput.Xattrs.
+	Xattrs bool
+}
+
+func NewExtractor(in *NewExtractorInput) Extractor {
+	return &extractor{
+		preserveOwner: in.PreserveOwner,
+		xattrs:        in.Xattrs,
+	}
+}
+
+type extractor struct {
+	preserveOwner bool
+	xattrs        bool
+}
+
+func (e *extractor) Do(ctx context.Context, r io.Reader, dest string) error {
+	tr := tar.NewReader(r)
+
+	h, err := tr.Next()
+	if errors.Is(err, io.EOF) {
+		// archive of an empty directory written without the root entry
+		if err := os.MkdirAll(dest, 0777); err != nil {
+			return fmt.Errorf("failed to create directory %q: %w", dest, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read tar header: %w", err)
+	}
+
+	// Archiver names the only entry of a single file archive after the file itself.
+	// Archives of directories written before the root entry was recorded start with their contents instead,
+	// so the entry of such an archive is written aside until we know whether another entry follows.
+	switch {
+	case h.Typeflag == tar.TypeDir && h.Name == rootEntryName:
+		// the root entry is extracted as dest, and restored along with the other directories.
+	case h.Typeflag == tar.TypeReg && h.Name == filepath.Base(dest):
+		tmp, err := e.writeTemp(tr, h, filepath.Dir(dest))
+		if err != nil {
+			return err
+		}
+		next, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			if err := removeSymlink(dest); err != nil {
+				_ = os.Remove(tmp)
+				return err
+			}
+			if err := os.Rename(tmp, dest); err != nil {
+				return fmt.Errorf("failed to rename %q to %q: %w", tmp, dest, err)
+			}
+			return nil
+		}
+		if err != nil {
+			_ = os.Remove(tmp)
+			return fmt.Errorf("failed to read tar header: %w", err)
+		}
+
+		if err := os.MkdirAll(dest, 0777); err != nil {
+			_ = os.Remove(tmp)
+			return fmt.Errorf("failed to create directory %q: %w", dest, err)
+		}
+		if err := os.Rename(tmp, filepath.Join(dest, h.Name)); err != nil {
+			_ = os.Remove(tmp)
+			return fmt.Errorf("failed to rename %q: %w", tmp, err)
+		}
+		h = next
+	case h.Typeflag == tar.TypeSymlink && h.Name == filepath.Base(dest):
+		// a symlink has no content, so the next entry can be read first.
+		next, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			if err := os.MkdirAll(filepath.Dir(dest), 0777); err != nil {
+				return fmt.Errorf("failed to create directory %q: %w", filepath.Dir(dest), err)
+			}
+			return e.writeSymlink(h, dest)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar header: %w", err)
+		}
+		if err := e.extract(tr, h, dest); err != nil {
+			return err
+		}
+		h = next
+	}
+
+	// directories are finished after their contents, because writing contents changes their modification times,
+	// and read-only directories could not be written.
+	dirs := []*tar.Header{}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := e.extract(tr, h, dest); err != nil {
+			return err
+		}
+		if h.Typeflag == tar.TypeDir {
+			dirs = append(dirs, h)
+		}
+
+		h, err = tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar header: %w", err)
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		path, err := safeJoin(dest, dirs[i].Name)
+		if err != nil {
+			return err
+		}
+		if err := e.restoreMetadata(dirs[i], path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extract writes the entry of h under the directory dest.
+func (e *extractor) extract(tr *tar.Reader, h *tar.Header, dest string) error {
+	path, err := safeJoin(dest, h.Name)
+	if err != nil {
+		return err
+	}
+	// a symlink extracted earlier must not redirect the entry outside of dest.
+	if err := checkSymlinkParents(dest, path); err != nil {
+		return err
+	}
+
+	switch h.Typeflag {
+	case tar.TypeDir:
+		if err := removeSymlink(path); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(path, 0777); err != nil {
+			return fmt.Errorf("failed to create directory %q: %w", path, err)
+		}
+	case tar.TypeReg:
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			return fmt.Errorf("failed to create directory %q: %w", filepath.Dir(path), err)
+		}
+		if err := removeSymlink(path); err != nil {
+			return err
+		}
+		if err := e.writeFile(tr, h, path); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			return fmt.Errorf("failed to create directory %q: %w", filepath.Dir(path), err)
+		}
+		if err := e.writeSymlink(h, path); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported type %q of tar entry %q", h.Typeflag, h.Name)
+	}
+	return nil
+}
+
+// writeTemp writes the entry of h to a temporary file in dir, and returns its path.
+func (e *extractor) writeTemp(tr *tar.Reader, h *tar.Header, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return "", fmt.Errorf("failed to create directory %q: %w", dir, err)
+	}
+	f, err := os.CreateTemp(dir, ".smart-syncer-extract-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	path := f.Name()
+	if err := f.Close(); err != nil {
+		_ = os.Remove(path)
+		return "", fmt.Errorf("failed to close temporary file: %w", err)
+	}
+	if err := e.writeFile(tr, h, path); err != nil {
+		_ = os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// writeFile writes the content of the current entry to path, and restores its mode and modification time.
+func (e *extractor) writeFile(tr *tar.Reader, h *tar.Header, path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create file %q: %w", path, err)
+	}
+	defer f.Close()
+
+	buf := bufPool.Get().(*[]byte)
+	defer bufPool.Put(buf)
+
+	if _, err := io.CopyBuffer(f, tr, *buf); err != nil {
+		return fmt.Errorf("failed to write file %q: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close file %q: %w", path, err)
+	}
+	return e.restoreMetadata(h, path)
+}
+
+// writeSymlink replaces the file at path with the symlink of h. Existing directories are not replaced.
+func (e *extractor) writeSymlink(h *tar.Header, path string) error {
+	if info, err := os.Lstat(path); err == nil && !info.IsDir() {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove %q: %w", path, err)
+		}
+	}
+	if err := os.Symlink(h.Linkname, path); err != nil {
+		return fmt.Errorf("failed to create symlink %q: %w", path, err)
+	}
+	return e.restoreOwner(h, path)
+}
+
+// restoreMetadata restores the owner, extended attributes, mode and modification time of the file at path.
+// The owner is restored first, because changing it clears setuid and setgid bits.
+func (e *extractor) restoreMetadata(h *tar.Header, path string) error {
+	if err := e.restoreOwner(h, path); err != nil {
+		return err
+	}
+	if e.xattrs {
+		attrs := map[string]string{}
+		for k, v := range h.PAXRecords {
+			if strings.HasPrefix(k, paxXattrPrefix) {
+				attrs[strings.TrimPrefix(k, paxXattrPrefix)] = v
+			}
+		}
+		if err := writeXattrs(path, attrs); err != nil {
+			return err
+		}
+	}
+	if err := os.Chmod(path, h.FileInfo().Mode()&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+		return fmt.Errorf("failed to change mode of %q: %w", path, err)
+	}
+	if err := os.Chtimes(path, h.ModTime, h.ModTime); err != nil {
+		return fmt.Errorf("failed to change times of %q: %w", path, err)
+	}
+	return nil
+}
+
+func (e *extractor) restoreOwner(h *tar.Header, path string) error {
+	if !e.preserveOwner {
+		return nil
+	}
+	if err := os.Lchown(path, h.Uid, h.Gid); err != nil && !errors.Is(err, fs.ErrPermission) {
+		return fmt.Errorf("failed to change owner of %q: %w", path, err)
+	}
+	return nil
+}
+
+// removeSymlink removes the file at path if it is a symlink, so that writing to path does not follow it.
+func removeSymlink(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		return nil
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove symlink %q: %w", path, err)
+	}
+	return nil
+}
+
+// checkSymlinkParents returns ErrUnsafePath if any directory between root and path is a symlink.
+func checkSymlinkParents(root string, path string) error {
+	rel, err := filepath.Rel(root, filepath.Dir(path))
+	// rel is ".." for the root entry, which is root itself.
+	if err != nil || rel == "." || rel == ".." {
+		return nil
+	}
+	p := root
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		p = filepath.Join(p, name)
+		info, err := os.Lstat(p)
+		if err != nil {
+			return nil
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %q is under symlink %q", ErrUnsafePath, path, p)
+		}
+	}
+	return nil
+}
+
+// safeJoin joins root and the slash-separated relative path name,
+// and returns ErrUnsafePath if the result is outside of root.
+func safeJoin(root, name string) (string, error) {
+	p := filepath.Clean(filepath.FromSlash(name))
+	if p == ".." || strings.HasPrefix(p, ".."+string(filepath.Separator)) ||
+		filepath.IsAbs(p) || filepath.VolumeName(p) != "" || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, name)
+	}
+	return filepath.Join(root, p), nil
+}
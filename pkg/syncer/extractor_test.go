@@ -0,0 +1,124 @@
+package syncer_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hareku/smart-syncer/pkg/syncer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+func TestExtractor_Do(t *testing.T) {
+	srcDir, err := os.MkdirTemp("", "extractor-do-test-src-")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, os.RemoveAll(srcDir))
+	})
+
+	require.NoError(t, os.Mkdir(filepath.Join(srcDir, "unit"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "unit/abc"), []byte("data for abc"), 0777))
+	require.NoError(t, os.Mkdir(filepath.Join(srcDir, "unit/def"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "unit/def/ghi"), []byte("data for ghi"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "file"), []byte("data for file"), 0777))
+
+	destDir, err := os.MkdirTemp("", "extractor-do-test-dest-")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, os.RemoveAll(destDir))
+	})
+
+	a := syncer.NewArchiver(&syncer.NewArchiverInput{})
+	e := syncer.NewExtractor(&syncer.NewExtractorInput{})
+	ctx := context.Background()
+
+	t.Run("directory", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		require.NoError(t, a.Do(ctx, filepath.Join(srcDir, "unit"), buf))
+		require.NoError(t, e.Do(ctx, buf, filepath.Join(destDir, "unit")))
+
+		expected, err := dirhash.HashDir(filepath.Join(srcDir, "unit"), "", dirhash.Hash1)
+		require.NoError(t, err)
+		got, err := dirhash.HashDir(filepath.Join(destDir, "unit"), "", dirhash.Hash1)
+		require.NoError(t, err)
+		assert.Equal(t, expected, got)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		require.NoError(t, a.Do(ctx, filepath.Join(srcDir, "file"), buf))
+		require.NoError(t, e.Do(ctx, buf, filepath.Join(destDir, "file")))
+
+		got, err := os.ReadFile(filepath.Join(destDir, "file"))
+		require.NoError(t, err)
+		assert.Equal(t, "data for file", string(got))
+	})
+
+	t.Run("directory with a file named after it", func(t *testing.T) {
+		unit := filepath.Join(srcDir, "photos")
+		require.NoError(t, os.Mkdir(unit, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(unit, "photos"), []byte("data for photos"), 0644))
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, a.Do(ctx, unit, buf))
+		require.NoError(t, e.Do(ctx, buf, filepath.Join(destDir, "photos")))
+
+		assert.DirExists(t, filepath.Join(destDir, "photos"))
+		got, err := os.ReadFile(filepath.Join(destDir, "photos/photos"))
+		require.NoError(t, err)
+		assert.Equal(t, "data for photos", string(got))
+		info, err := os.Stat(filepath.Join(destDir, "photos"))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	})
+
+	t.Run("path traversal", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		tw := tar.NewWriter(buf)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "ok", Typeflag: tar.TypeReg, Mode: 0666}))
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0666}))
+		require.NoError(t, tw.Close())
+
+		err := e.Do(ctx, buf, filepath.Join(destDir, "traversal"))
+		assert.True(t, errors.Is(err, syncer.ErrUnsafePath), err)
+		assert.NoFileExists(t, filepath.Join(destDir, "evil"))
+	})
+
+	t.Run("symlinks and empty directories", func(t *testing.T) {
+		unit := filepath.Join(srcDir, "links")
+		require.NoError(t, os.MkdirAll(filepath.Join(unit, "empty"), 0777))
+		require.NoError(t, os.WriteFile(filepath.Join(unit, "abc"), []byte("data for abc"), 0777))
+		require.NoError(t, os.Symlink("abc", filepath.Join(unit, "link")))
+		require.NoError(t, os.Symlink("/nonexistent", filepath.Join(unit, "dangling")))
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, a.Do(ctx, unit, buf))
+		require.NoError(t, e.Do(ctx, buf, filepath.Join(destDir, "links")))
+
+		assert.DirExists(t, filepath.Join(destDir, "links/empty"))
+		target, err := os.Readlink(filepath.Join(destDir, "links/link"))
+		require.NoError(t, err)
+		assert.Equal(t, "abc", target)
+		target, err = os.Readlink(filepath.Join(destDir, "links/dangling"))
+		require.NoError(t, err)
+		assert.Equal(t, "/nonexistent", target)
+	})
+
+	t.Run("symlink traversal", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		tw := tar.NewWriter(buf)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: destDir}))
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "link/evil", Typeflag: tar.TypeReg, Mode: 0666}))
+		require.NoError(t, tw.Close())
+
+		err := e.Do(ctx, buf, filepath.Join(destDir, "symlink-traversal"))
+		assert.True(t, errors.Is(err, syncer.ErrUnsafePath), err)
+		assert.NoFileExists(t, filepath.Join(destDir, "evil"))
+	})
+}
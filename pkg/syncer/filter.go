@@ -0,0 +1,287 @@
+package syncer
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// IgnoreFileName is the name of per-directory files of exclude patterns.
+// Patterns in it are relative to the directory, and take precedence over ones in parent directories and options.
+const IgnoreFileName = ".syncignore"
+
+// Filter excludes files under the source directory by gitignore-style patterns.
+// Patterns are matched against slash-separated paths relative to the root, and the last matching pattern wins.
+// A nil *Filter excludes nothing.
+type Filter struct {
+	root  string
+	rules []filterRule
+
+	mu sync.Mutex
+	// ignoreFiles caches rules in IgnoreFileName by the directory relative to the root.
+	ignoreFiles map[string][]filterRule
+}
+
+type NewFilterInput struct {
+	// Root is the source directory.
+	Root string
+	// Excludes are patterns of files to exclude.
+	Excludes []string
+	// Includes are patterns of files to include even if they match Excludes.
+	Includes []string
+	// ExcludeFrom are files of patterns in the gitignore format, applied after Excludes and Includes.
+	ExcludeFrom []string
+}
+
+type filterRule struct {
+	pattern string
+	// base is the directory relative to the root which the pattern is relative to, or empty for the root.
+	base    string
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+func NewFilter(in *NewFilterInput) (*Filter, error) {
+	root, err := filepath.Abs(in.Root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path of %q: %w", in.Root, err)
+	}
+	f := &Filter{
+		root:        root,
+		ignoreFiles: map[string][]filterRule{},
+	}
+
+	for _, p := range in.Excludes {
+		if err := f.add(p, ""); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range in.Includes {
+		if err := f.add("!"+p, ""); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range in.ExcludeFrom {
+		lines, err := readPatterns(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range lines {
+			if err := f.add(p, ""); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return f, nil
+}
+
+func (f *Filter) add(pattern string, base string) error {
+	r, ok, err := parseFilterRule(pattern, base)
+	if err != nil {
+		return err
+	}
+	if ok {
+		f.rules = append(f.rules, r)
+	}
+	return nil
+}
+
+// Excluded reports whether the file at path is excluded.
+// Callers must not descend into excluded directories, because their contents are not checked separately.
+func (f *Filter) Excluded(p string, isDir bool) (bool, error) {
+	if f == nil {
+		return false, nil
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return false, fmt.Errorf("failed to get absolute path of %q: %w", p, err)
+	}
+	rel, err := filepath.Rel(f.root, abs)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false, nil
+	}
+	rel = filepath.ToSlash(rel)
+
+	// ignore files in the root and in every ancestor directory of the file
+	dirs := []string{""}
+	if d := path.Dir(rel); d != "." {
+		names := strings.Split(d, "/")
+		for i := range names {
+			dirs = append(dirs, strings.Join(names[:i+1], "/"))
+		}
+	}
+	rules := f.rules[:len(f.rules):len(f.rules)]
+	for _, dir := range dirs {
+		rs, err := f.ignoreFile(dir)
+		if err != nil {
+			return false, err
+		}
+		rules = append(rules, rs...)
+	}
+
+	excluded := false
+	for _, r := range rules {
+		if r.match(rel, isDir) {
+			excluded = !r.negate
+		}
+	}
+	return excluded, nil
+}
+
+// ignoreFile returns the rules in IgnoreFileName of the directory relative to the root.
+func (f *Filter) ignoreFile(dir string) ([]filterRule, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if rules, ok := f.ignoreFiles[dir]; ok {
+		return rules, nil
+	}
+	name := filepath.Join(f.root, filepath.FromSlash(dir), IgnoreFileName)
+	lines, err := readPatterns(name)
+	if errors.Is(err, os.ErrNotExist) {
+		lines = nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	rules := []filterRule{}
+	for _, p := range lines {
+		r, ok, err := parseFilterRule(p, dir)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if ok {
+			rules = append(rules, r)
+		}
+	}
+	f.ignoreFiles[dir] = rules
+	return rules, nil
+}
+
+// Digest returns a digest of the patterns given by options, to detect changes of them.
+// Patterns in IgnoreFileName are not included, because the files are in the source.
+func (f *Filter) Digest() string {
+	if f == nil || len(f.rules) == 0 {
+		return ""
+	}
+	h := sha256.New()
+	for _, r := range f.rules {
+		fmt.Fprintf(h, "%s\n", r.pattern)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func readPatterns(name string) ([]string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pattern file: %w", err)
+	}
+	defer file.Close()
+
+	res := []string{}
+	s := bufio.NewScanner(file)
+	for s.Scan() {
+		res = append(res, s.Text())
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read pattern file %q: %w", name, err)
+	}
+	return res, nil
+}
+
+// parseFilterRule parses a line in the gitignore format. It returns false for blank lines and comments.
+func parseFilterRule(pattern string, base string) (filterRule, bool, error) {
+	r := filterRule{pattern: pattern, base: base}
+	p := strings.TrimRight(pattern, " \t\r")
+	if p == "" || strings.HasPrefix(p, "#") {
+		return r, false, nil
+	}
+	if strings.HasPrefix(p, "!") {
+		r.negate = true
+		p = p[1:]
+	} else if strings.HasPrefix(p, `\`) {
+		p = p[1:]
+	}
+	if strings.HasSuffix(p, "/") {
+		r.dirOnly = true
+		p = strings.TrimRight(p, "/")
+	}
+	if p == "" {
+		return r, false, fmt.Errorf("invalid pattern %q", pattern)
+	}
+
+	// a pattern with a slash is relative to the base, otherwise it matches a name at any depth.
+	expr := "(?:.*/)?" + globToRegexp(p)
+	if strings.Contains(p, "/") {
+		expr = globToRegexp(strings.TrimPrefix(p, "/"))
+	}
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return r, false, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	r.re = re
+	return r, true, nil
+}
+
+func globToRegexp(p string) string {
+	b := strings.Builder{}
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; c {
+		case '*':
+			switch {
+			case strings.HasPrefix(p[i:], "**/"):
+				b.WriteString("(?:.*/)?")
+				i += 2
+			case p[i:] == "**":
+				b.WriteString(".*")
+				i++
+			default:
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(p[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := p[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(p) {
+				i++
+			}
+			b.WriteString(regexp.QuoteMeta(p[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+func (r filterRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.base != "" {
+		if !strings.HasPrefix(rel, r.base+"/") {
+			return false
+		}
+		rel = strings.TrimPrefix(rel, r.base+"/")
+	}
+	return r.re.MatchString(rel)
+}
@@ -0,0 +1,137 @@
+package syncer_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/hareku/smart-syncer/pkg/syncer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter_Excluded(t *testing.T) {
+	dir, err := os.MkdirTemp("", "filter-test-")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, os.RemoveAll(dir))
+	})
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "a/b"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a", syncer.IgnoreFileName), []byte("# comment\n/local.txt\n!keep.tmp\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "patterns"), []byte("*.log\n"), 0644))
+
+	f, err := syncer.NewFilter(&syncer.NewFilterInput{
+		Root:        dir,
+		Excludes:    []string{"node_modules/", "*.tmp", "/top", "a/**/deep", ".DS_Store"},
+		Includes:    []string{"important.tmp"},
+		ExcludeFrom: []string{filepath.Join(dir, "patterns")},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{path: "node_modules", isDir: true, want: true},
+		{path: "a/b/node_modules", isDir: true, want: true},
+		{path: "node_modules", want: false},
+		{path: "a/x.tmp", want: true},
+		{path: "a/important.tmp", want: false},
+		{path: "top", want: true},
+		{path: "a/top", want: false},
+		{path: "a/deep", want: true},
+		{path: "a/b/c/deep", want: true},
+		{path: "b/deep", want: false},
+		{path: "a/b/.DS_Store", want: true},
+		{path: "x.log", want: true},
+		{path: "a/local.txt", want: true},
+		{path: "local.txt", want: false},
+		{path: "a/b/local.txt", want: false},
+		{path: "a/b/keep.tmp", want: false},
+		{path: "keep.tmp", want: true},
+	}
+	for _, tt := range tests {
+		got, err := f.Excluded(filepath.Join(dir, filepath.FromSlash(tt.path)), tt.isDir)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, got, tt.path)
+	}
+
+	var nilFilter *syncer.Filter
+	got, err := nilFilter.Excluded(filepath.Join(dir, "x.tmp"), false)
+	require.NoError(t, err)
+	assert.False(t, got)
+}
+
+func TestFilter_Walkers(t *testing.T) {
+	dir, err := os.MkdirTemp("", "filter-test-")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, os.RemoveAll(dir))
+	})
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "unit/node_modules"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "cache"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "unit/main.js"), []byte("main"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "unit/node_modules/dep.js"), []byte("dep"), 0644))
+
+	f, err := syncer.NewFilter(&syncer.NewFilterInput{
+		Root:     dir,
+		Excludes: []string{"node_modules/", "/cache"},
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	objs, err := syncer.NewLocalStorage(&syncer.NewLocalStorageInput{Filter: f}).List(ctx, dir, 1)
+	require.NoError(t, err)
+	require.Len(t, objs, 1)
+	assert.Equal(t, "unit", objs[0].Key)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, syncer.NewArchiver(&syncer.NewArchiverInput{Filter: f}).Do(ctx, filepath.Join(dir, "unit"), buf))
+	names := tarNames(t, buf)
+	assert.Equal(t, []string{"./", "main.js"}, names)
+
+	t.Run("fingerprint", func(t *testing.T) {
+		s := syncer.NewLocalStorage(&syncer.NewLocalStorageInput{Filter: f})
+		before, err := s.Fingerprint(ctx, filepath.Join(dir, "unit"))
+		require.NoError(t, err)
+
+		// excluded files do not matter
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "unit/node_modules/dep.js"), []byte("new dep"), 0644))
+		got, err := s.Fingerprint(ctx, filepath.Join(dir, "unit"))
+		require.NoError(t, err)
+		assert.Equal(t, before, got)
+
+		// but patterns do
+		other, err := syncer.NewFilter(&syncer.NewFilterInput{
+			Root:     dir,
+			Excludes: []string{"node_modules/"},
+		})
+		require.NoError(t, err)
+		got, err = syncer.NewLocalStorage(&syncer.NewLocalStorageInput{Filter: other}).Fingerprint(ctx, filepath.Join(dir, "unit"))
+		require.NoError(t, err)
+		assert.NotEqual(t, before, got)
+	})
+}
+
+func tarNames(t *testing.T, r *bytes.Buffer) []string {
+	t.Helper()
+	names := []string{}
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, h.Name)
+	}
+	sort.Strings(names)
+	return names
+}
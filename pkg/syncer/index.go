@@ -0,0 +1,277 @@
+package syncer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// IndexKey is the key of the index object, which is a gzipped JSON listing every object in the repository.
+const IndexKey = ".index.json.gz"
+
+// IndexRepository is a Repository which lists objects from an index object instead of a full listing.
+type IndexRepository interface {
+	Repository
+	// SaveIndex uploads the index if objects were changed since it was loaded.
+	SaveIndex(ctx context.Context) error
+	// RebuildIndex replaces the index with a full listing of the underlying repository.
+	RebuildIndex(ctx context.Context) error
+}
+
+// NewIndexRepository wraps the repository to maintain an index.
+// The index is loaded on the first List, or rebuilt if it does not exist yet,
+// and kept up to date by Upload and Delete until SaveIndex uploads it.
+// Changes made without the index, such as by older versions, are not reflected until RebuildIndex.
+// The returned repository is a TrashRepository if repo is.
+func NewIndexRepository(repo Repository) IndexRepository {
+	r := &indexRepository{repo: repo}
+	if tr, ok := repo.(TrashRepository); ok {
+		return &indexTrashRepository{indexRepository: r, trash: tr}
+	}
+	return r
+}
+
+type indexRepository struct {
+	repo Repository
+
+	mu      sync.Mutex
+	objects map[string]RepositoryObject // nil until loaded
+	dirty   bool
+}
+
+type indexFile struct {
+	Version int           `json:"version"`
+	Objects []indexObject `json:"objects"`
+}
+
+type indexObject struct {
+	Key              string   `json:"key"`
+	LastModifiedUnix int64    `json:"lastModifiedUnix"`
+	Size             int64    `json:"size"`
+	Metadata         Metadata `json:"metadata"`
+}
+
+const indexVersion = 1
+
+func (s *indexRepository) List(ctx context.Context) ([]RepositoryObject, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(ctx); err != nil {
+		return nil, err
+	}
+	res := make([]RepositoryObject, 0, len(s.objects))
+	for _, o := range s.objects {
+		res = append(res, o)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Key < res[j].Key
+	})
+	return res, nil
+}
+
+// load loads the index if not loaded yet. s.mu must be held.
+func (s *indexRepository) load(ctx context.Context) error {
+	if s.objects != nil {
+		return nil
+	}
+
+	rc, err := s.repo.Download(ctx, IndexKey)
+	if errors.Is(err, ErrNotFound) {
+		return s.rebuild(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download index: %w", err)
+	}
+	defer rc.Close()
+
+	gr, err := gzip.NewReader(rc)
+	if err != nil {
+		return fmt.Errorf("failed to decompress index: %w", err)
+	}
+	idx := indexFile{}
+	if err := json.NewDecoder(gr).Decode(&idx); err != nil {
+		return fmt.Errorf("failed to decode index: %w", err)
+	}
+	if idx.Version != indexVersion {
+		return s.rebuild(ctx)
+	}
+
+	s.objects = make(map[string]RepositoryObject, len(idx.Objects))
+	for _, o := range idx.Objects {
+		s.objects[o.Key] = RepositoryObject{
+			Key:              o.Key,
+			LastModifiedUnix: o.LastModifiedUnix,
+			Size:             o.Size,
+			Metadata:         o.Metadata,
+		}
+	}
+	return nil
+}
+
+// rebuild replaces the index with a full listing. s.mu must be held.
+func (s *indexRepository) rebuild(ctx context.Context) error {
+	objs, err := s.repo.List(ctx)
+	if err != nil {
+		return err
+	}
+	// the index keeps metadata, so that listing with it needs no request per object.
+	if mr, ok := s.repo.(MetadataRepository); ok {
+		if err := mr.FillMetadata(ctx, objs); err != nil {
+			return err
+		}
+	}
+	s.objects = make(map[string]RepositoryObject, len(objs))
+	for _, o := range objs {
+		if o.Key == IndexKey {
+			continue
+		}
+		s.objects[o.Key] = o
+	}
+	s.dirty = true
+	return nil
+}
+
+func (s *indexRepository) RebuildIndex(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.rebuild(ctx); err != nil {
+		return fmt.Errorf("failed to rebuild index: %w", err)
+	}
+	return nil
+}
+
+func (s *indexRepository) SaveIndex(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.dirty {
+		return nil
+	}
+	idx := indexFile{
+		Version: indexVersion,
+		Objects: make([]indexObject, 0, len(s.objects)),
+	}
+	for _, o := range s.objects {
+		idx.Objects = append(idx.Objects, indexObject{
+			Key:              o.Key,
+			LastModifiedUnix: o.LastModifiedUnix,
+			Size:             o.Size,
+			Metadata:         o.Metadata,
+		})
+	}
+	sort.Slice(idx.Objects, func(i, j int) bool {
+		return idx.Objects[i].Key < idx.Objects[j].Key
+	})
+
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	if err := json.NewEncoder(gw).Encode(idx); err != nil {
+		return fmt.Errorf("failed to encode index: %w", err)
+	}
+	if err := gw.Close(); err != nil {
+		return fmt.Errorf("failed to compress index: %w", err)
+	}
+	if err := s.repo.Upload(ctx, IndexKey, buf, Metadata{}); err != nil {
+		return fmt.Errorf("failed to upload index: %w", err)
+	}
+	s.dirty = false
+	return nil
+}
+
+func (s *indexRepository) Upload(ctx context.Context, key string, r io.Reader, meta Metadata) error {
+	return s.upload(key, r, meta, func(r io.Reader) error {
+		return s.repo.Upload(ctx, key, r, meta)
+	})
+}
+
+// UploadResumable resumes the upload if the underlying repository is a ResumableRepository, and uploads normally otherwise.
+func (s *indexRepository) UploadResumable(ctx context.Context, key string, r io.Reader, meta Metadata, journal *Journal) error {
+	rr, ok := s.repo.(ResumableRepository)
+	if !ok {
+		return s.Upload(ctx, key, r, meta)
+	}
+	return s.upload(key, r, meta, func(r io.Reader) error {
+		return rr.UploadResumable(ctx, key, r, meta, journal)
+	})
+}
+
+// upload calls fn with r, and records the object uploaded by it in the index.
+func (s *indexRepository) upload(key string, r io.Reader, meta Metadata, fn func(r io.Reader) error) error {
+	dr := newDigestReader(r)
+	if err := fn(dr); err != nil {
+		return err
+	}
+	// the same bytes as the repository received, whose digest it records
+	meta.SHA256 = dr.sum()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.objects != nil {
+		s.objects[key] = RepositoryObject{
+			Key:              key,
+			LastModifiedUnix: time.Now().Unix(),
+			Size:             dr.n,
+			Metadata:         meta,
+		}
+		s.dirty = true
+	}
+	return nil
+}
+
+func (s *indexRepository) Delete(ctx context.Context, keys []string) error {
+	err := s.repo.Delete(ctx, keys)
+	s.forget(keys, err)
+	return err
+}
+
+// forget removes the keys from the index, except ones which failed by *DeleteError.
+func (s *indexRepository) forget(keys []string, err error) {
+	deleted := deletedKeys(keys, err)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.objects == nil {
+		return
+	}
+	for _, k := range deleted {
+		delete(s.objects, k)
+		s.dirty = true
+	}
+}
+
+func (s *indexRepository) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.repo.Download(ctx, key)
+}
+
+// FillDigest gets the digest stored aside by the underlying repository,
+// which the index does not keep for objects listed by RebuildIndex.
+func (s *indexRepository) FillDigest(ctx context.Context, obj *RepositoryObject) error {
+	if dr, ok := s.repo.(DigestRepository); ok {
+		return dr.FillDigest(ctx, obj)
+	}
+	return nil
+}
+
+type indexTrashRepository struct {
+	*indexRepository
+	trash TrashRepository
+}
+
+func (s *indexTrashRepository) Trash(ctx context.Context, keys []string, at time.Time) error {
+	err := s.trash.Trash(ctx, keys, at)
+	s.forget(keys, err)
+	return err
+}
+
+func (s *indexTrashRepository) PurgeTrash(ctx context.Context, before time.Time) ([]string, error) {
+	return s.trash.PurgeTrash(ctx, before)
+}
@@ -0,0 +1,84 @@
+package syncer_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/hareku/smart-syncer/pkg/syncer"
+	"github.com/hareku/smart-syncer/pkg/syncer/syncermock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexRepository(t *testing.T) {
+	ctx := context.Background()
+	mem := syncer.NewRepositoryMem()
+	require.NoError(t, mem.Upload(ctx, "abc.tar", strings.NewReader("data for abc"), syncer.Metadata{}))
+
+	keys := func(objs []syncer.RepositoryObject) []string {
+		res := []string{}
+		for _, o := range objs {
+			res = append(res, o.Key)
+		}
+		return res
+	}
+
+	// the first List builds the index from a full listing
+	ir := syncer.NewIndexRepository(mem)
+	got, err := ir.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"abc.tar"}, keys(got))
+
+	require.NoError(t, ir.Upload(ctx, "def.tar", strings.NewReader("data for def"), syncer.Metadata{Fingerprint: "h1:def"}))
+	require.NoError(t, ir.Delete(ctx, []string{"abc.tar"}))
+	require.NoError(t, ir.SaveIndex(ctx))
+
+	t.Run("load", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		repo := syncermock.NewMockRepository(ctrl)
+		repo.EXPECT().Download(gomock.Any(), syncer.IndexKey).Times(1).DoAndReturn(mem.Download)
+
+		ir := syncer.NewIndexRepository(repo)
+		_, ok := ir.(syncer.TrashRepository)
+		assert.False(t, ok)
+
+		got, err := ir.List(ctx)
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		assert.Equal(t, "def.tar", got[0].Key)
+		assert.Equal(t, int64(len("data for def")), got[0].Size)
+		assert.Equal(t, syncer.Metadata{Fingerprint: "h1:def", SHA256: fmt.Sprintf("%x", sha256.Sum256([]byte("data for def")))}, got[0].Metadata)
+	})
+
+	t.Run("download error", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		repo := syncermock.NewMockRepository(ctrl)
+		repo.EXPECT().Download(gomock.Any(), syncer.IndexKey).Times(1).Return(nil, errors.New("access denied"))
+
+		_, err := syncer.NewIndexRepository(repo).List(ctx)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "access denied")
+	})
+
+	t.Run("rebuild", func(t *testing.T) {
+		require.NoError(t, mem.Upload(ctx, "ghi.tar", strings.NewReader("data for ghi"), syncer.Metadata{}))
+
+		ir := syncer.NewIndexRepository(mem)
+		_, ok := ir.(syncer.TrashRepository)
+		assert.True(t, ok)
+
+		got, err := ir.List(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"def.tar"}, keys(got))
+
+		require.NoError(t, ir.RebuildIndex(ctx))
+		got, err = ir.List(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"def.tar", "ghi.tar"}, keys(got))
+	})
+}
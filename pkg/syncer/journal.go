@@ -0,0 +1,283 @@
+package syncer
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	journalPlanFile      = "plan.json"
+	journalCompletedFile = "completed"
+	journalUploadsFile   = "uploads.json"
+)
+
+// journalMaxAge is the age of plans which are no longer resumed, because the source has likely changed much since.
+const journalMaxAge = 24 * time.Hour
+
+// Journal records the progress of runs in a local directory, so that the next run can resume an interrupted one.
+// It keeps the plan of the run, keys uploaded or deleted so far, and IDs of multipart uploads in progress.
+// A nil *Journal records nothing.
+type Journal struct {
+	dir string
+
+	mu sync.Mutex
+	// uploads are multipart uploads in progress by the key.
+	uploads map[string]MultipartUpload
+}
+
+// MultipartUpload is a multipart upload in progress, which can be resumed by uploading the rest of parts.
+type MultipartUpload struct {
+	ID string `json:"id"`
+	// Metadata is the metadata which the upload was initiated with.
+	Metadata Metadata `json:"metadata"`
+	// Parts are ETags of uploaded parts by their numbers.
+	Parts map[int64]string `json:"parts,omitempty"`
+}
+
+type journalPlan struct {
+	CreatedAt time.Time       `json:"createdAt"`
+	Input     *ClientRunInput `json:"input"`
+	Plan      *SyncPlan       `json:"plan"`
+}
+
+// DefaultJournalDir returns the directory of the journal for the repository URL, under the user cache directory.
+func DefaultJournalDir(repoURL string) (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get cache directory: %w", err)
+	}
+	sum := sha256.Sum256([]byte(repoURL))
+	return filepath.Join(cache, "smart-syncer", hex.EncodeToString(sum[:8])), nil
+}
+
+// OpenJournal opens the journal in dir, creating it if it does not exist.
+func OpenJournal(dir string) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+	j := &Journal{
+		dir:     dir,
+		uploads: map[string]MultipartUpload{},
+	}
+	b, err := os.ReadFile(filepath.Join(dir, journalUploadsFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+	if err := json.Unmarshal(b, &j.uploads); err != nil {
+		return nil, fmt.Errorf("failed to decode journal: %w", err)
+	}
+	return j, nil
+}
+
+// begin records the plan of a new run, forgetting the progress of the previous one.
+func (j *Journal) begin(in *ClientRunInput, plan *SyncPlan) error {
+	if j == nil {
+		return nil
+	}
+	b, err := json.Marshal(journalPlan{CreatedAt: time.Now(), Input: in, Plan: plan})
+	if err != nil {
+		return fmt.Errorf("failed to encode plan for journal: %w", err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := os.Remove(filepath.Join(j.dir, journalCompletedFile)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to reset journal: %w", err)
+	}
+	return writeFileAtomic(filepath.Join(j.dir, journalPlanFile), b)
+}
+
+// resume returns the plan of the interrupted run with the same input, and keys completed in it.
+// It returns a nil plan if there is no such run.
+func (j *Journal) resume(in *ClientRunInput) (*SyncPlan, map[string]bool, error) {
+	if j == nil {
+		return nil, nil, nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	b, err := os.ReadFile(filepath.Join(j.dir, journalPlanFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+	jp := journalPlan{}
+	if err := json.Unmarshal(b, &jp); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode journal: %w", err)
+	}
+	if jp.Plan == nil || jp.Input == nil || *jp.Input != *in || time.Since(jp.CreatedAt) > journalMaxAge {
+		return nil, nil, nil
+	}
+
+	completed := map[string]bool{}
+	f, err := os.Open(filepath.Join(j.dir, journalCompletedFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return jp.Plan, completed, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		completed[s.Text()] = true
+	}
+	if err := s.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+	return jp.Plan, completed, nil
+}
+
+// complete records that the keys were uploaded or deleted.
+func (j *Journal) complete(keys ...string) error {
+	if j == nil || len(keys) == 0 {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	f, err := os.OpenFile(filepath.Join(j.dir, journalCompletedFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	for _, k := range keys {
+		fmt.Fprintln(w, k)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	return nil
+}
+
+// finish forgets the plan and progress of the run which was applied successfully.
+func (j *Journal) finish() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, name := range []string{journalPlanFile, journalCompletedFile} {
+		if err := os.Remove(filepath.Join(j.dir, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to clear journal: %w", err)
+		}
+	}
+	return nil
+}
+
+// MultipartUpload returns the multipart upload in progress for the key.
+func (j *Journal) MultipartUpload(key string) (MultipartUpload, bool) {
+	if j == nil {
+		return MultipartUpload{}, false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	u, ok := j.uploads[key]
+	// parts are copied, because SaveMultipartPart may add ones while the caller reads them.
+	if u.Parts != nil {
+		parts := make(map[int64]string, len(u.Parts))
+		for num, etag := range u.Parts {
+			parts[num] = etag
+		}
+		u.Parts = parts
+	}
+	return u, ok
+}
+
+// SaveMultipartUpload records the multipart upload in progress for the key.
+func (j *Journal) SaveMultipartUpload(key string, u MultipartUpload) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.uploads[key] = u
+	return j.saveUploads()
+}
+
+// SaveMultipartPart records the part uploaded to the multipart upload in progress for the key.
+func (j *Journal) SaveMultipartPart(key string, num int64, etag string) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	u, ok := j.uploads[key]
+	if !ok {
+		return fmt.Errorf("no multipart upload for %q in journal", key)
+	}
+	if u.Parts == nil {
+		u.Parts = map[int64]string{}
+	}
+	u.Parts[num] = etag
+	j.uploads[key] = u
+	return j.saveUploads()
+}
+
+// DeleteMultipartUpload forgets the multipart upload for the key, which was completed or aborted.
+func (j *Journal) DeleteMultipartUpload(key string) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.uploads[key]; !ok {
+		return nil
+	}
+	delete(j.uploads, key)
+	return j.saveUploads()
+}
+
+// saveUploads writes j.uploads. j.mu must be held.
+func (j *Journal) saveUploads() error {
+	b, err := json.Marshal(j.uploads)
+	if err != nil {
+		return fmt.Errorf("failed to encode journal: %w", err)
+	}
+	return writeFileAtomic(filepath.Join(j.dir, journalUploadsFile), b)
+}
+
+// writeFileAtomic writes the file via a temporary file, so that a crash does not leave it partially written.
+func writeFileAtomic(name string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(name), ".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		_ = os.Remove(f.Name())
+		return fmt.Errorf("failed to write %q: %w", name, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		_ = os.Remove(f.Name())
+		return fmt.Errorf("failed to sync %q: %w", name, err)
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return fmt.Errorf("failed to write %q: %w", name, err)
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		_ = os.Remove(f.Name())
+		return fmt.Errorf("failed to rename %q: %w", f.Name(), err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package syncer_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/hareku/smart-syncer/pkg/syncer"
+	"github.com/hareku/smart-syncer/pkg/syncer/syncermock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Run_Journal(t *testing.T) {
+	dir, err := os.MkdirTemp("", "journal-test-")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, os.RemoveAll(dir))
+	})
+	in := &syncer.ClientRunInput{Path: "target", Depth: 1}
+	ctx := context.Background()
+
+	t.Run("interrupted", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		repo := syncermock.NewMockRepository(ctrl)
+		repo.EXPECT().List(gomock.Any()).Return([]syncer.RepositoryObject{
+			{Key: "obj3.tar", LastModifiedUnix: 30},
+		}, nil)
+		repo.EXPECT().Upload(gomock.Any(), "obj1.tar", gomock.Any(), gomock.Any()).Return(nil)
+		repo.EXPECT().Upload(gomock.Any(), "obj2.tar", gomock.Any(), gomock.Any()).Return(errors.New("connection reset"))
+
+		local := syncermock.NewMockLocalStorage(ctrl)
+		local.EXPECT().List(gomock.Any(), "target", 1).Return([]syncer.LocalObject{
+			{Key: "obj1", LastModifiedUnix: 10},
+			{Key: "obj2", LastModifiedUnix: 20},
+		}, nil)
+
+		arc := syncermock.NewMockArchiver(ctrl)
+		arc.EXPECT().Do(gomock.Any(), filepath.Join("target/obj1"), gomock.Any()).Return(nil)
+		arc.EXPECT().Do(gomock.Any(), filepath.Join("target/obj2"), gomock.Any()).Return(nil)
+
+		journal, err := syncer.OpenJournal(dir)
+		require.NoError(t, err)
+		c := &syncer.Client{
+			LocalStorage: local,
+			Repository:   repo,
+			Archiver:     arc,
+			Concurrency:  1,
+			Journal:      journal,
+		}
+		require.Error(t, c.Run(ctx, in))
+	})
+
+	t.Run("resumed", func(t *testing.T) {
+		// neither the source nor the repository is listed, and obj1 is not uploaded again.
+		ctrl := gomock.NewController(t)
+		repo := syncermock.NewMockRepository(ctrl)
+		repo.EXPECT().Upload(gomock.Any(), "obj2.tar", gomock.Any(), syncer.Metadata{LocalModifiedUnix: 20, Format: "tar"}).Return(nil)
+		repo.EXPECT().Delete(gomock.Any(), []string{"obj3.tar"}).Return(nil)
+
+		arc := syncermock.NewMockArchiver(ctrl)
+		arc.EXPECT().Do(gomock.Any(), filepath.Join("target/obj2"), gomock.Any()).Return(nil)
+
+		journal, err := syncer.OpenJournal(dir)
+		require.NoError(t, err)
+		c := &syncer.Client{
+			LocalStorage: syncermock.NewMockLocalStorage(ctrl),
+			Repository:   repo,
+			Archiver:     arc,
+			Concurrency:  1,
+			Journal:      journal,
+		}
+		require.NoError(t, c.Run(ctx, in))
+	})
+
+	t.Run("finished", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		repo := syncermock.NewMockRepository(ctrl)
+		repo.EXPECT().List(gomock.Any()).Return([]syncer.RepositoryObject{}, nil)
+		local := syncermock.NewMockLocalStorage(ctrl)
+		local.EXPECT().List(gomock.Any(), "target", 1).Return([]syncer.LocalObject{}, nil)
+
+		journal, err := syncer.OpenJournal(dir)
+		require.NoError(t, err)
+		c := &syncer.Client{
+			LocalStorage: local,
+			Repository:   repo,
+			Archiver:     syncermock.NewMockArchiver(ctrl),
+			Journal:      journal,
+		}
+		require.NoError(t, c.Run(ctx, in))
+	})
+}
+
+func TestJournal_Nil(t *testing.T) {
+	var j *syncer.Journal
+	_, ok := j.MultipartUpload("abc.tar")
+	assert.False(t, ok)
+	assert.NoError(t, j.SaveMultipartUpload("abc.tar", syncer.MultipartUpload{ID: "upload-1"}))
+	assert.NoError(t, j.SaveMultipartPart("abc.tar", 1, `"etag"`))
+	assert.NoError(t, j.DeleteMultipartUpload("abc.tar"))
+}
@@ -3,26 +3,55 @@ package syncer
 import (
 	"context"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/sumdb/dirhash"
 )
 
 //go:generate mockgen -destination ./${GOPACKAGE}mock/${GOFILE} -package ${GOPACKAGE}mock -source ./${GOFILE}
 
 type LocalObject struct {
-	Key              string
+	Key string
+	// LastModifiedUnix is the newest modification time of the object.
+	// For a directory, it covers every file and directory in its subtree.
 	LastModifiedUnix int64
+	// Fingerprint is a digest of the object's content, set only when fingerprinting is enabled.
+	Fingerprint string
+	// Files is the number of regular files and symlinks in the object, and Size is the total size of the regular files.
+	Files int64
+	Size  int64
 }
 
 type LocalStorage interface {
 	List(ctx context.Context, path string, depth int) ([]LocalObject, error)
+	// Fingerprint returns a deterministic digest of the files under path.
+	// It depends only on file names, contents, symlink targets and empty directories, not on modification times.
+	Fingerprint(ctx context.Context, path string) (string, error)
 }
 
-func NewLocalStorage() LocalStorage {
-	return &localStorage{}
+type NewLocalStorageInput struct {
+	// Filter excludes files from units and their fingerprints, if not nil.
+	Filter *Filter
+	// FollowSymlinks walks targets of symlinks instead of symlinks themselves, as NewArchiverInput.FollowSymlinks.
+	FollowSymlinks bool
 }
 
-type localStorage struct{}
+func NewLocalStorage(in *NewLocalStorageInput) LocalStorage {
+	return &localStorage{
+		walker: walker{
+			filter:         in.Filter,
+			followSymlinks: in.FollowSymlinks,
+		},
+	}
+}
+
+type localStorage struct {
+	walker walker
+}
 
 func (s *localStorage) List(ctx context.Context, root string, depth int) ([]LocalObject, error) {
 	res := []LocalObject{}
@@ -40,26 +69,121 @@ func (s *localStorage) recurse(ctx context.Context, root string, path string, de
 	}
 	for _, e := range entries {
 		curPath := filepath.Join(path, e.Name())
-		if e.IsDir() && currentDepth < depth {
+		info, err := s.walker.stat(curPath)
+		if err != nil {
+			return err
+		}
+		if excluded, err := s.walker.filter.Excluded(curPath, info.IsDir()); err != nil {
+			return err
+		} else if excluded {
+			continue
+		}
+		if info.IsDir() && currentDepth < depth {
 			if err := s.recurse(ctx, root, curPath, depth, currentDepth+1, res); err != nil {
 				return fmt.Errorf("error in path %q depth %d: %w", curPath, currentDepth+1, err)
 			}
 			continue
 		}
 
-		info, err := e.Info()
-		if err != nil {
-			return fmt.Errorf("failed to get info of %q: %w", curPath, err)
-		}
 		key, err := filepath.Rel(root, curPath)
 		if err != nil {
 			return fmt.Errorf("failed to get relative path: %w", err)
 		}
-
-		*res = append(*res, LocalObject{
-			Key:              filepath.ToSlash(key),
-			LastModifiedUnix: info.ModTime().Unix(),
-		})
+		obj := LocalObject{Key: filepath.ToSlash(key)}
+		if err := s.summarize(ctx, curPath, &obj); err != nil {
+			return fmt.Errorf("failed to summarize %q: %w", curPath, err)
+		}
+		*res = append(*res, obj)
 	}
 	return nil
 }
+
+// summarize sets the newest modification time in the subtree of root including root itself, and the number and size of files in it.
+// Directories are taken into account for the time, so that removing or renaming a file is also detected.
+func (s *localStorage) summarize(ctx context.Context, root string, obj *LocalObject) error {
+	return s.walker.walk(ctx, root, func(path string, rel string, info fs.FileInfo) error {
+		if t := info.ModTime().Unix(); t > obj.LastModifiedUnix {
+			obj.LastModifiedUnix = t
+		}
+		switch {
+		case info.Mode().IsRegular():
+			obj.Files++
+			obj.Size += info.Size()
+		case info.Mode()&fs.ModeSymlink != 0:
+			obj.Files++
+		}
+		return nil
+	})
+}
+
+func (s *localStorage) Fingerprint(ctx context.Context, root string) (string, error) {
+	// dir is the directory which file names are relative to.
+	dir := root
+	var files []string
+	// virtual are contents of entries which are not regular files.
+	virtual := map[string]string{}
+	// children counts entries in each directory, to find empty ones.
+	children := map[string]int{}
+	err := s.walker.walk(ctx, root, func(path string, rel string, info fs.FileInfo) error {
+		name := filepath.ToSlash(rel)
+		// if rel is ".", root is file (not dir).
+		if rel == "." {
+			if info.IsDir() {
+				return nil
+			}
+			name = filepath.Base(root)
+			dir = filepath.Dir(root)
+		} else {
+			children[filepath.ToSlash(filepath.Dir(rel))]++
+		}
+
+		mode := info.Mode()
+		switch {
+		case mode.IsDir():
+			if _, ok := children[name]; !ok {
+				children[name] = 0
+			}
+		case mode&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return fmt.Errorf("failed to read symlink %q: %w", path, err)
+			}
+			files = append(files, name)
+			virtual[name] = "symlink:" + target
+		case mode.IsRegular():
+			files = append(files, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to walk %q: %w", root, err)
+	}
+	// empty directories are hashed as empty files with a trailing slash, which no real file can have,
+	// so that adding or removing one changes the fingerprint. non-empty ones are implied by the paths of their entries.
+	for d, n := range children {
+		if n == 0 {
+			files = append(files, d+"/")
+			virtual[d+"/"] = ""
+		}
+	}
+
+	// patterns given by options are hashed as a file which no real file can collide with,
+	// so that changing them changes the fingerprint.
+	const filterName = "\x00filter"
+	digest := s.walker.filter.Digest()
+	if digest != "" {
+		files = append(files, filterName)
+		virtual[filterName] = digest
+	}
+	open := func(name string) (io.ReadCloser, error) {
+		if v, ok := virtual[name]; ok {
+			return io.NopCloser(strings.NewReader(v)), nil
+		}
+		return os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+	}
+	h, err := dirhash.Hash1(files, open)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash %q: %w", root, err)
+	}
+	return h, nil
+}
@@ -3,13 +3,16 @@ package syncer_test
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/hareku/smart-syncer/pkg/syncer"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/mod/sumdb/dirhash"
 )
 
 func TestLocalStorage_List(t *testing.T) {
@@ -37,7 +40,7 @@ func TestLocalStorage_List(t *testing.T) {
 	require.NoError(t, writeFile("jkl/mno/pqr"))
 	require.NoError(t, writeFile("jkl/mno1"))
 
-	s := syncer.NewLocalStorage()
+	s := syncer.NewLocalStorage(&syncer.NewLocalStorageInput{})
 
 	tests := []struct {
 		depth    int
@@ -64,3 +67,98 @@ func TestLocalStorage_List(t *testing.T) {
 		})
 	}
 }
+
+func TestLocalStorage_List_LastModified(t *testing.T) {
+	dir, err := os.MkdirTemp("", "localstorage-list-lastmodified-test-")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, os.RemoveAll(dir))
+	})
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "photos/2023"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "photos/2023/img.jpg"), []byte("data for img.jpg"), 0777))
+
+	old := time.Unix(1000, 0)
+	newest := time.Unix(2000, 0)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "photos/2023/img.jpg"), newest, newest))
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "photos/2023"), old, old))
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "photos"), old, old))
+
+	got, err := syncer.NewLocalStorage(&syncer.NewLocalStorageInput{}).List(context.Background(), dir, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []syncer.LocalObject{
+		{
+			Key:              "photos",
+			LastModifiedUnix: newest.Unix(),
+			Files:            1,
+			Size:             int64(len("data for img.jpg")),
+		},
+	}, got)
+}
+
+func TestLocalStorage_Fingerprint(t *testing.T) {
+	dir, err := os.MkdirTemp("", "localstorage-fingerprint-test-")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, os.RemoveAll(dir))
+	})
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "abc"), []byte("data for abc"), 0777))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "def"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "def/ghi"), []byte("data for ghi"), 0777))
+
+	s := syncer.NewLocalStorage(&syncer.NewLocalStorageInput{})
+	ctx := context.Background()
+
+	got, err := s.Fingerprint(ctx, dir)
+	require.NoError(t, err)
+	expected, err := dirhash.HashDir(dir, "", dirhash.Hash1)
+	require.NoError(t, err)
+	assert.Equal(t, expected, got)
+
+	t.Run("modification time does not matter", func(t *testing.T) {
+		now := time.Now().Add(time.Hour)
+		require.NoError(t, os.Chtimes(filepath.Join(dir, "def/ghi"), now, now))
+		got2, err := s.Fingerprint(ctx, dir)
+		require.NoError(t, err)
+		assert.Equal(t, got, got2)
+	})
+
+	t.Run("content matters", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "def/ghi"), []byte("new data for ghi"), 0777))
+		got2, err := s.Fingerprint(ctx, dir)
+		require.NoError(t, err)
+		assert.NotEqual(t, got, got2)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		got, err := s.Fingerprint(ctx, filepath.Join(dir, "abc"))
+		require.NoError(t, err)
+		expected, err := dirhash.Hash1([]string{"abc"}, func(string) (io.ReadCloser, error) {
+			return os.Open(filepath.Join(dir, "abc"))
+		})
+		require.NoError(t, err)
+		assert.Equal(t, expected, got)
+	})
+
+	t.Run("symlinks and empty directories matter", func(t *testing.T) {
+		prev, err := s.Fingerprint(ctx, dir)
+		require.NoError(t, err)
+
+		require.NoError(t, os.Mkdir(filepath.Join(dir, "empty"), 0777))
+		got, err := s.Fingerprint(ctx, dir)
+		require.NoError(t, err)
+		assert.NotEqual(t, prev, got)
+
+		require.NoError(t, os.Symlink("abc", filepath.Join(dir, "link")))
+		withLink, err := s.Fingerprint(ctx, dir)
+		require.NoError(t, err)
+		assert.NotEqual(t, got, withLink)
+
+		require.NoError(t, os.Remove(filepath.Join(dir, "link")))
+		require.NoError(t, os.Symlink("def", filepath.Join(dir, "link")))
+		got, err = s.Fingerprint(ctx, dir)
+		require.NoError(t, err)
+		assert.NotEqual(t, withLink, got)
+	})
+}
@@ -0,0 +1,351 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+type SyncActionType string
+
+const (
+	// SyncActionUploadNew uploads a unit which is not in the repository.
+	SyncActionUploadNew SyncActionType = "upload-new"
+	// SyncActionUploadChanged uploads a unit which was changed since the last upload.
+	SyncActionUploadChanged SyncActionType = "upload-changed"
+	// SyncActionDelete deletes an archive of a unit removed from the source, or an archive superseded by another.
+	SyncActionDelete SyncActionType = "delete"
+	// SyncActionSkip leaves a unit as it is.
+	SyncActionSkip SyncActionType = "skip"
+)
+
+type SyncAction struct {
+	Type SyncActionType `json:"type"`
+	Unit string         `json:"unit"`
+	// Key is the repository key to upload to or delete. For skip, it is the archive kept as it is.
+	Key    string `json:"key"`
+	Reason string `json:"reason,omitempty"`
+	// Fingerprint, LastModifiedUnix, Files and Size are of the local unit, and recorded in the metadata of the uploaded archive.
+	Fingerprint      string `json:"fingerprint,omitempty"`
+	LastModifiedUnix int64  `json:"lastModifiedUnix,omitempty"`
+	Files            int64  `json:"files,omitempty"`
+	Size             int64  `json:"size,omitempty"`
+}
+
+func (a SyncAction) isUpload() bool {
+	return a.Type == SyncActionUploadNew || a.Type == SyncActionUploadChanged
+}
+
+// SyncPlan is the actions of a run, made by Client.Plan and taken by Client.Apply.
+type SyncPlan struct {
+	// Path is the source directory.
+	Path string `json:"path"`
+	// Compression and Encryption are the names of the compression and encryption scheme which archives are uploaded with.
+	// Apply requires the client to have the same ones.
+	Compression string `json:"compression"`
+	Encryption  string `json:"encryption,omitempty"`
+	// RepositoryUnits is the number of units in the repository before the run.
+	RepositoryUnits int          `json:"repositoryUnits"`
+	Actions         []SyncAction `json:"actions"`
+	// Snapshot is the snapshot to be written after uploading, in snapshot mode.
+	Snapshot *Snapshot `json:"snapshot,omitempty"`
+	// SourceCheck is the check of the source when the plan was made, which Apply runs again before deleting.
+	SourceCheck *SourceCheck `json:"sourceCheck,omitempty"`
+}
+
+// checkSource runs the check of the source again, because it may have been unmounted since the plan was made.
+func (p *SyncPlan) checkSource() error {
+	if p.SourceCheck == nil {
+		return nil
+	}
+	return p.SourceCheck.Check(p.Path)
+}
+
+// Count returns the number of actions of the type.
+func (p *SyncPlan) Count(typ SyncActionType) int {
+	n := 0
+	for _, a := range p.Actions {
+		if a.Type == typ {
+			n++
+		}
+	}
+	return n
+}
+
+// Plan compares the source with the repository and returns actions to sync them, without changing anything.
+func (c *Client) Plan(ctx context.Context, in *ClientRunInput) (*SyncPlan, error) {
+	if err := c.precheck(in); err != nil {
+		return nil, err
+	}
+
+	repoObjects, err := c.Repository.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects from repository: %w", err)
+	}
+	if c.Snapshot {
+		return c.planSnapshot(ctx, in, repoObjects)
+	}
+
+	// stale are archives to be deleted even though their units exist locally,
+	// because they are superseded by archives with the current compression.
+	stale := []SyncAction{}
+	inRepo := map[string]RepositoryObject{}
+	for _, obj := range repoObjects {
+		unit, _, ok := parseArchiveKey(obj.Key)
+		if !ok || strings.HasPrefix(obj.Key, SnapshotPrefix) {
+			continue
+		}
+		if prev, ok := inRepo[unit]; ok {
+			// keep the archive with the current compression to compare with.
+			if obj.Key == c.archiveKey(unit) {
+				stale = append(stale, SyncAction{Type: SyncActionDelete, Unit: unit, Key: prev.Key, Reason: fmt.Sprintf("superseded by %q", obj.Key)})
+				inRepo[unit] = obj
+			} else {
+				stale = append(stale, SyncAction{Type: SyncActionDelete, Unit: unit, Key: obj.Key, Reason: fmt.Sprintf("superseded by %q", prev.Key)})
+			}
+			continue
+		}
+		inRepo[unit] = obj
+	}
+
+	localObjects, err := c.listLocal(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkEmpty(in, localObjects, len(inRepo)); err != nil {
+		return nil, err
+	}
+	unfilled, err := c.fillMetadata(ctx, localObjects, inRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := c.newPlan(in)
+	plan.RepositoryUnits = len(inRepo)
+	for _, localObj := range localObjects {
+		action := SyncAction{
+			Type:             SyncActionUploadNew,
+			Unit:             localObj.Key,
+			Key:              c.archiveKey(localObj.Key),
+			Fingerprint:      localObj.Fingerprint,
+			LastModifiedUnix: localObj.LastModifiedUnix,
+			Files:            localObj.Files,
+			Size:             localObj.Size,
+		}
+
+		repoObj, ok := inRepo[localObj.Key]
+		if ok {
+			delete(inRepo, localObj.Key)
+
+			action.Type = SyncActionUploadChanged
+			if !unfilled[localObj.Key] {
+				action.Reason = c.changeReason(localObj, repoObj)
+			}
+			if action.Reason == "" {
+				action.Type = SyncActionSkip
+				action.Key = repoObj.Key
+				action.Reason = "unchanged"
+			} else if repoObj.Key != action.Key {
+				stale = append(stale, SyncAction{Type: SyncActionDelete, Unit: localObj.Key, Key: repoObj.Key, Reason: fmt.Sprintf("superseded by %q", action.Key)})
+			}
+		}
+		plan.Actions = append(plan.Actions, action)
+	}
+
+	removed := make([]SyncAction, 0, len(inRepo))
+	for unit, obj := range inRepo {
+		removed = append(removed, SyncAction{Type: SyncActionDelete, Unit: unit, Key: obj.Key, Reason: "removed from source"})
+	}
+	sort.Slice(removed, func(i, j int) bool {
+		return removed[i].Key < removed[j].Key
+	})
+	plan.Actions = append(plan.Actions, removed...)
+	plan.Actions = append(plan.Actions, stale...)
+	return plan, nil
+}
+
+// fillMetadata gets the metadata of archives which are compared with the local objects,
+// if the repository does not list it. Archives of removed units are deleted without it.
+// It returns the units whose archives are unchanged by their listing, whose metadata is not got
+// because a request per archive on every run would cost far more than the listing.
+func (c *Client) fillMetadata(ctx context.Context, localObjects []LocalObject, inRepo map[string]RepositoryObject) (map[string]bool, error) {
+	mr, ok := c.Repository.(MetadataRepository)
+	if !ok {
+		return nil, nil
+	}
+	unfilled := map[string]bool{}
+	units := []string{}
+	objs := []RepositoryObject{}
+	for _, localObj := range localObjects {
+		obj, ok := inRepo[localObj.Key]
+		if !ok {
+			continue
+		}
+		if c.unchangedByListing(localObj, obj) {
+			unfilled[localObj.Key] = true
+			continue
+		}
+		units = append(units, localObj.Key)
+		objs = append(objs, obj)
+	}
+	if len(objs) == 0 {
+		return unfilled, nil
+	}
+	if err := mr.FillMetadata(ctx, objs); err != nil {
+		return nil, fmt.Errorf("failed to get metadata from repository: %w", err)
+	}
+	for i, unit := range units {
+		inRepo[unit] = objs[i]
+	}
+	return unfilled, nil
+}
+
+// precheck checks the client and the source before planning.
+func (c *Client) precheck(in *ClientRunInput) error {
+	if _, ok := c.Repository.(TrashRepository); c.SoftDelete && !ok {
+		return fmt.Errorf("repository does not support soft deletion")
+	}
+	if c.SourceCheck != nil {
+		if err := c.SourceCheck.Check(in.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) newPlan(in *ClientRunInput) *SyncPlan {
+	return &SyncPlan{
+		Path:        in.Path,
+		Compression: c.compression().Name(),
+		Encryption:  c.encryptionScheme(),
+		Actions:     []SyncAction{},
+		SourceCheck: c.SourceCheck,
+	}
+}
+
+// Apply takes the actions of the plan: uploads, then writing the snapshot if any, and deletes.
+func (c *Client) Apply(ctx context.Context, plan *SyncPlan) error {
+	err := c.apply(ctx, plan)
+	// save the index even if applying failed or was canceled, so that it reflects objects uploaded so far.
+	saveCtx, cancel := graceContext(ctx, c.GracePeriod)
+	defer cancel()
+	if indexErr := c.saveIndex(saveCtx); err == nil {
+		err = indexErr
+	}
+	return err
+}
+
+func (c *Client) apply(ctx context.Context, plan *SyncPlan) error {
+	if plan.Compression != c.compression().Name() {
+		return fmt.Errorf("plan is made with compression %q, but the client has %q", plan.Compression, c.compression().Name())
+	}
+	if plan.Encryption != c.encryptionScheme() {
+		return fmt.Errorf("plan is made with encryption %q, but the client has %q", plan.Encryption, c.encryptionScheme())
+	}
+	if err := plan.checkSource(); err != nil {
+		return err
+	}
+
+	uploads := []SyncAction{}
+	deleteActions := []SyncAction{}
+	localUnits := map[string]bool{}
+	for _, a := range plan.Actions {
+		switch {
+		case a.isUpload():
+			uploads = append(uploads, a)
+		case a.Type == SyncActionDelete:
+			deleteActions = append(deleteActions, a)
+		}
+		if a.Type != SyncActionDelete {
+			localUnits[a.Unit] = true
+		}
+	}
+	removedUnits := map[string]bool{}
+	for _, a := range plan.Actions {
+		if a.Type == SyncActionDelete && !localUnits[a.Unit] {
+			removedUnits[a.Unit] = true
+		}
+	}
+	if err := c.checkDeletes(len(removedUnits), plan.RepositoryUnits); err != nil {
+		return err
+	}
+
+	failures, err := c.uploadAll(ctx, plan.Path, uploads)
+	if err != nil {
+		return err
+	}
+	failed := map[string]bool{}
+	for _, f := range failures {
+		failed[f.Unit] = true
+	}
+
+	// a snapshot must not refer to archives which failed to be uploaded.
+	// archives uploaded without the snapshot are deleted by Prune.
+	if plan.Snapshot != nil && len(failures) > 0 {
+		log.Printf("Skipping snapshot %s because %d units failed", plan.Snapshot.ID, len(failures))
+	} else if plan.Snapshot != nil {
+		log.Printf("Creating snapshot %s with %d units", plan.Snapshot.ID, len(plan.Snapshot.Units))
+		if !c.Dryrun {
+			if err := c.saveSnapshot(ctx, plan.Snapshot); err != nil {
+				return err
+			}
+		}
+	}
+
+	// archives superseded by ones which failed to be uploaded are still the latest ones of the units.
+	deletes := []string{}
+	for _, a := range deleteActions {
+		if failed[a.Unit] {
+			log.Printf("Skipping deletion of %s because %s failed", a.Key, a.Unit)
+			continue
+		}
+		deletes = append(deletes, a.Key)
+	}
+	if len(deletes) > 0 {
+		// the source may have been unmounted while uploading.
+		if err := plan.checkSource(); err != nil {
+			return err
+		}
+		for i, k := range deletes {
+			log.Printf("Deleting(%d/%d): %s", i+1, len(deletes), k)
+		}
+		if !c.Dryrun {
+			err := c.delete(ctx, deletes)
+			if journalErr := c.Journal.complete(deletedKeys(deletes, err)...); err == nil {
+				err = journalErr
+			}
+			if err != nil {
+				return fmt.Errorf("failed to delete objects: %w", err)
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		return &UnitsFailedError{Failures: failures, Total: len(uploads)}
+	}
+	return nil
+}
+
+// deletedKeys returns the keys which were deleted by a call returning err.
+func deletedKeys(keys []string, err error) []string {
+	var delErr *DeleteError
+	if err == nil {
+		return keys
+	} else if !errors.As(err, &delErr) {
+		return nil
+	}
+	failed := map[string]bool{}
+	for _, k := range delErr.Keys() {
+		failed[k] = true
+	}
+	res := []string{}
+	for _, k := range keys {
+		if !failed[k] {
+			res = append(res, k)
+		}
+	}
+	return res
+}
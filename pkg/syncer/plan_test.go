@@ -0,0 +1,203 @@
+package syncer_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/golang/mock/gomock"
+	"github.com/hareku/smart-syncer/pkg/syncer"
+	"github.com/hareku/smart-syncer/pkg/syncer/syncermock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Plan(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	repo := syncermock.NewMockRepository(ctrl)
+	repo.EXPECT().List(gomock.Any()).Times(1).Return([]syncer.RepositoryObject{
+		{Key: "obj1.tar", LastModifiedUnix: 1},
+		{Key: "obj2.tar", LastModifiedUnix: 20},
+		{Key: "obj3.tar.gz", LastModifiedUnix: 20},
+		{Key: "obj4.tar", LastModifiedUnix: 40},
+		// recorded modification times of local units are compared instead of upload times.
+		{Key: "obj6.tar", LastModifiedUnix: 100, Metadata: syncer.Metadata{LocalModifiedUnix: 60}},
+		{Key: "obj7.tar", LastModifiedUnix: 1, Metadata: syncer.Metadata{LocalModifiedUnix: 70}},
+	}, nil)
+
+	local := syncermock.NewMockLocalStorage(ctrl)
+	local.EXPECT().List(gomock.Any(), "target", 1).Times(1).Return([]syncer.LocalObject{
+		{Key: "obj1", LastModifiedUnix: 10},
+		{Key: "obj2", LastModifiedUnix: 20},
+		{Key: "obj3", LastModifiedUnix: 10},
+		{Key: "obj5", LastModifiedUnix: 50, Files: 2, Size: 30},
+		{Key: "obj6", LastModifiedUnix: 70},
+		{Key: "obj7", LastModifiedUnix: 70},
+	}, nil)
+
+	// nothing is archived nor changed in the repository
+	c := &syncer.Client{
+		LocalStorage: local,
+		Repository:   repo,
+		Archiver:     syncermock.NewMockArchiver(ctrl),
+	}
+	plan, err := c.Plan(context.Background(), &syncer.ClientRunInput{
+		Path:  "target",
+		Depth: 1,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, &syncer.SyncPlan{
+		Path:            "target",
+		Compression:     "none",
+		RepositoryUnits: 6,
+		Actions: []syncer.SyncAction{
+			{Type: syncer.SyncActionUploadChanged, Unit: "obj1", Key: "obj1.tar", Reason: "modified after upload", LastModifiedUnix: 10},
+			{Type: syncer.SyncActionSkip, Unit: "obj2", Key: "obj2.tar", Reason: "unchanged", LastModifiedUnix: 20},
+			{Type: syncer.SyncActionUploadChanged, Unit: "obj3", Key: "obj3.tar", Reason: "compression changed", LastModifiedUnix: 10},
+			{Type: syncer.SyncActionUploadNew, Unit: "obj5", Key: "obj5.tar", LastModifiedUnix: 50, Files: 2, Size: 30},
+			{Type: syncer.SyncActionUploadChanged, Unit: "obj6", Key: "obj6.tar", Reason: "modified since archived", LastModifiedUnix: 70},
+			{Type: syncer.SyncActionSkip, Unit: "obj7", Key: "obj7.tar", Reason: "unchanged", LastModifiedUnix: 70},
+			{Type: syncer.SyncActionDelete, Unit: "obj4", Key: "obj4.tar", Reason: "removed from source"},
+			{Type: syncer.SyncActionDelete, Unit: "obj3", Key: "obj3.tar.gz", Reason: `superseded by "obj3.tar"`},
+		},
+	}, plan)
+	assert.Equal(t, 3, plan.Count(syncer.SyncActionUploadChanged))
+
+	t.Run("apply with another compression", func(t *testing.T) {
+		comp, err := syncer.NewCompression("gzip")
+		require.NoError(t, err)
+		c := &syncer.Client{
+			Repository:  repo,
+			Compression: comp,
+		}
+		assert.Error(t, c.Apply(context.Background(), plan))
+	})
+}
+
+func TestClient_Plan_MetadataRepository(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	repo := syncermock.NewMockMetadataRepository(ctrl)
+	repo.EXPECT().List(gomock.Any()).Times(1).Return([]syncer.RepositoryObject{
+		{Key: "obj1.tar", LastModifiedUnix: 1},
+		{Key: "obj2.tar", LastModifiedUnix: 1},
+		{Key: "obj3.tar", LastModifiedUnix: 20},
+	}, nil)
+	// only the archive modified after upload is asked for, and not the one uploaded after the unit was modified
+	// or the one of the removed unit.
+	repo.EXPECT().FillMetadata(gomock.Any(), []syncer.RepositoryObject{{Key: "obj1.tar", LastModifiedUnix: 1}}).Times(1).
+		DoAndReturn(func(ctx context.Context, objs []syncer.RepositoryObject) error {
+			objs[0].Metadata = syncer.Metadata{LocalModifiedUnix: 10}
+			return nil
+		})
+
+	local := syncermock.NewMockLocalStorage(ctrl)
+	local.EXPECT().List(gomock.Any(), "target", 1).Times(1).Return([]syncer.LocalObject{
+		{Key: "obj1", LastModifiedUnix: 10},
+		{Key: "obj3", LastModifiedUnix: 10},
+	}, nil)
+
+	c := &syncer.Client{
+		LocalStorage: local,
+		Repository:   repo,
+	}
+	plan, err := c.Plan(context.Background(), &syncer.ClientRunInput{
+		Path:  "target",
+		Depth: 1,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []syncer.SyncAction{
+		{Type: syncer.SyncActionSkip, Unit: "obj1", Key: "obj1.tar", Reason: "unchanged", LastModifiedUnix: 10},
+		{Type: syncer.SyncActionSkip, Unit: "obj3", Key: "obj3.tar", Reason: "unchanged", LastModifiedUnix: 10},
+		{Type: syncer.SyncActionDelete, Unit: "obj2", Key: "obj2.tar", Reason: "removed from source"},
+	}, plan.Actions)
+}
+
+func TestClient_Plan_S3(t *testing.T) {
+	var mu sync.Mutex
+	heads := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.Method {
+		case http.MethodGet:
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult><IsTruncated>false</IsTruncated>`+
+				`<Contents><Key>prefix/obj1.tar</Key><LastModified>2022-02-22T00:00:00.000Z</LastModified><Size>10</Size></Contents>`+
+				`<Contents><Key>prefix/obj2.tar</Key><LastModified>2022-02-22T00:00:00.000Z</LastModified><Size>10</Size></Contents>`+
+				`</ListBucketResult>`)
+		case http.MethodHead:
+			heads = append(heads, r.URL.Path)
+			w.Header().Set("X-Amz-Meta-Fingerprint", "h1:obj")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	sess, err := session.NewSession(aws.NewConfig().
+		WithCredentials(credentials.NewStaticCredentials("access", "secret", "")).
+		WithRegion("us-east-1").WithEndpoint(srv.URL).WithS3ForcePathStyle(true).WithMaxRetries(0))
+	require.NoError(t, err)
+	repo := syncer.NewRepositoryS3(&syncer.NewRepositoryS3Input{
+		Bucket: "bucket",
+		Prefix: "prefix",
+		API:    s3.New(sess),
+	})
+	uploaded := time.Date(2022, 2, 22, 0, 0, 0, 0, time.UTC).Unix()
+
+	for _, tc := range []struct {
+		name        string
+		fingerprint bool
+		modified    int64
+		heads       []string
+		reason      string
+	}{
+		// an unchanged tree needs only the listing.
+		{name: "unchanged", modified: uploaded - 1, heads: []string{}, reason: "unchanged"},
+		{name: "modified after upload", modified: uploaded + 1, heads: []string{"/bucket/prefix/obj1.tar", "/bucket/prefix/obj2.tar"}, reason: "modified after upload"},
+		{name: "fingerprint", fingerprint: true, modified: uploaded - 1, heads: []string{"/bucket/prefix/obj1.tar", "/bucket/prefix/obj2.tar"}, reason: "unchanged"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mu.Lock()
+			heads = []string{}
+			mu.Unlock()
+
+			ctrl := gomock.NewController(t)
+			local := syncermock.NewMockLocalStorage(ctrl)
+			local.EXPECT().List(gomock.Any(), "target", 1).Times(1).Return([]syncer.LocalObject{
+				{Key: "obj1", LastModifiedUnix: tc.modified},
+				{Key: "obj2", LastModifiedUnix: tc.modified},
+			}, nil)
+			local.EXPECT().Fingerprint(gomock.Any(), gomock.Any()).AnyTimes().Return("h1:obj", nil)
+
+			c := &syncer.Client{
+				LocalStorage: local,
+				Repository:   repo,
+				Fingerprint:  tc.fingerprint,
+			}
+			plan, err := c.Plan(context.Background(), &syncer.ClientRunInput{
+				Path:  "target",
+				Depth: 1,
+			})
+			require.NoError(t, err)
+			mu.Lock()
+			assert.ElementsMatch(t, tc.heads, heads)
+			mu.Unlock()
+			require.Len(t, plan.Actions, 2)
+			for _, a := range plan.Actions {
+				assert.Equal(t, tc.reason, a.Reason, a.Unit)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// RepositoryOpener creates a repository from a URL of the scheme it is registered for.
+type RepositoryOpener func(ctx context.Context, u *url.URL) (Repository, error)
+
+var (
+	openersMu sync.RWMutex
+	openers   = map[string]RepositoryOpener{}
+)
+
+// RegisterRepository makes a repository backend available by URLs of the scheme.
+// Registering a scheme again replaces the previous opener,
+// so that applications can configure built-in backends.
+func RegisterRepository(scheme string, opener RepositoryOpener) {
+	openersMu.Lock()
+	defer openersMu.Unlock()
+	openers[scheme] = opener
+}
+
+// RepositorySchemes returns the registered URL schemes in sorted order.
+func RepositorySchemes() []string {
+	openersMu.RLock()
+	defer openersMu.RUnlock()
+	res := make([]string, 0, len(openers))
+	for s := range openers {
+		res = append(res, s)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// OpenRepository opens the repository of the URL,
+// such as "s3://bucket/prefix?region=us-east-1", "file:///mnt/backup" and "mem://name".
+func OpenRepository(ctx context.Context, rawURL string) (Repository, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse repository URL: %w", err)
+	}
+
+	openersMu.RLock()
+	opener, ok := openers[u.Scheme]
+	openersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported repository scheme %q, available: %v", u.Scheme, RepositorySchemes())
+	}
+
+	repo, err := opener(ctx, u)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s repository: %w", u.Scheme, err)
+	}
+	return repo, nil
+}
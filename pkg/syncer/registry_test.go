@@ -0,0 +1,81 @@
+package syncer_test
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hareku/smart-syncer/pkg/syncer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenRepository(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("mem", func(t *testing.T) {
+		repo1, err := syncer.OpenRepository(ctx, "mem://open-repository-test")
+		require.NoError(t, err)
+		require.NoError(t, repo1.Upload(ctx, "abc.tar", strings.NewReader("data for abc"), syncer.Metadata{Fingerprint: "h1:abc"}))
+
+		repo2, err := syncer.OpenRepository(ctx, "mem://open-repository-test")
+		require.NoError(t, err)
+		objs, err := repo2.List(ctx)
+		require.NoError(t, err)
+		require.Len(t, objs, 1)
+		assert.Equal(t, "abc.tar", objs[0].Key)
+		assert.Equal(t, "h1:abc", objs[0].Metadata.Fingerprint)
+
+		rc, err := repo2.Download(ctx, "abc.tar")
+		require.NoError(t, err)
+		b, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		assert.Equal(t, "data for abc", string(b))
+
+		require.NoError(t, repo2.Delete(ctx, []string{"abc.tar"}))
+		objs, err = repo1.List(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, objs)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		dir, err := os.MkdirTemp("", "open-repository-test-")
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			assert.NoError(t, os.RemoveAll(dir))
+		})
+
+		repo, err := syncer.OpenRepository(ctx, (&url.URL{Scheme: "file", Path: dir}).String())
+		require.NoError(t, err)
+		assert.IsType(t, &syncer.RepositoryFS{}, repo)
+	})
+
+	t.Run("s3", func(t *testing.T) {
+		repo, err := syncer.OpenRepository(ctx, "s3://bucket/prefix?region=us-east-1")
+		require.NoError(t, err)
+		assert.IsType(t, &syncer.RepositoryS3{}, repo)
+
+		_, err = syncer.OpenRepository(ctx, "s3:///prefix")
+		assert.Error(t, err)
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		var opened *url.URL
+		syncer.RegisterRepository("custom", func(ctx context.Context, u *url.URL) (syncer.Repository, error) {
+			opened = u
+			return syncer.NewRepositoryMem(), nil
+		})
+		_, err := syncer.OpenRepository(ctx, "custom://host/path?k=v")
+		require.NoError(t, err)
+		assert.Equal(t, "host", opened.Host)
+		assert.Equal(t, "v", opened.Query().Get("k"))
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := syncer.OpenRepository(ctx, "unknown://bucket")
+		assert.Error(t, err)
+	})
+}
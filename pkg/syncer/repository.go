@@ -2,7 +2,16 @@ package syncer
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
 	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
 )
 
 //go:generate mockgen -destination ./${GOPACKAGE}mock/${GOFILE} -package ${GOPACKAGE}mock -source ./${GOFILE}
@@ -10,10 +19,254 @@ import (
 type RepositoryObject struct {
 	Key              string
 	LastModifiedUnix int64
+	// Size is the size of the object in bytes.
+	Size     int64
+	Metadata Metadata
+}
+
+// Metadata is information stored alongside an object in the repository.
+type Metadata struct {
+	// Fingerprint is the content digest of the local object the archive was made from.
+	Fingerprint string
+	// Encryption is the scheme of Encryption which the archive is encrypted with, or empty if not encrypted.
+	Encryption string
+	// LocalModifiedUnix is the newest modification time of the local object when it was archived.
+	LocalModifiedUnix int64
+	// LocalFiles and LocalSize are the number of files and their total size in bytes of the local object when it was archived.
+	LocalFiles int64
+	LocalSize  int64
+	// Format is the format of the archive, such as "tar" and "tar+gzip".
+	Format string
+	// Version is the version of the tool which uploaded the archive.
+	Version string
+	// Hostname is the host which uploaded the archive.
+	Hostname string
+	// SHA256 is the hex-encoded SHA-256 digest of the object as stored, computed by the repository while uploading.
+	// Repositories ignore the given value. It is empty for objects which were uploaded without it.
+	SHA256 string
+}
+
+const (
+	metadataFingerprint   = "Fingerprint"
+	metadataEncryption    = "Encryption"
+	metadataLocalModified = "Local-Modified"
+	metadataLocalFiles    = "Local-Files"
+	metadataLocalSize     = "Local-Size"
+	metadataFormat        = "Format"
+	metadataVersion       = "Version"
+	metadataHostname      = "Hostname"
+	metadataSHA256        = "Sha256"
+)
+
+// toMap converts m into a key-value form which backends can store.
+// Empty fields are omitted.
+func (m Metadata) toMap() map[string]string {
+	res := map[string]string{}
+	if m.Fingerprint != "" {
+		res[metadataFingerprint] = m.Fingerprint
+	}
+	if m.Encryption != "" {
+		res[metadataEncryption] = m.Encryption
+	}
+	if m.LocalModifiedUnix != 0 {
+		res[metadataLocalModified] = strconv.FormatInt(m.LocalModifiedUnix, 10)
+	}
+	if m.LocalFiles != 0 {
+		res[metadataLocalFiles] = strconv.FormatInt(m.LocalFiles, 10)
+	}
+	if m.LocalSize != 0 {
+		res[metadataLocalSize] = strconv.FormatInt(m.LocalSize, 10)
+	}
+	if m.Format != "" {
+		res[metadataFormat] = m.Format
+	}
+	if m.Version != "" {
+		res[metadataVersion] = m.Version
+	}
+	if m.Hostname != "" {
+		res[metadataHostname] = m.Hostname
+	}
+	if m.SHA256 != "" {
+		res[metadataSHA256] = m.SHA256
+	}
+	return res
+}
+
+// metadataFromMap is the inverse of Metadata.toMap.
+// Keys are matched case-insensitively because backends may canonicalize them.
+func metadataFromMap(mm map[string]string) Metadata {
+	canonical := canonicalMetadata(mm)
+	localModified, _ := strconv.ParseInt(canonical[metadataLocalModified], 10, 64)
+	localFiles, _ := strconv.ParseInt(canonical[metadataLocalFiles], 10, 64)
+	localSize, _ := strconv.ParseInt(canonical[metadataLocalSize], 10, 64)
+	return Metadata{
+		Fingerprint:       canonical[metadataFingerprint],
+		Encryption:        canonical[metadataEncryption],
+		LocalModifiedUnix: localModified,
+		LocalFiles:        localFiles,
+		LocalSize:         localSize,
+		Format:            canonical[metadataFormat],
+		Version:           canonical[metadataVersion],
+		Hostname:          canonical[metadataHostname],
+		SHA256:            canonical[metadataSHA256],
+	}
+}
+
+// canonicalMetadata returns mm with canonicalized keys.
+func canonicalMetadata(mm map[string]string) map[string]string {
+	res := make(map[string]string, len(mm))
+	for k, v := range mm {
+		res[textproto.CanonicalMIMEHeaderKey(k)] = v
+	}
+	return res
+}
+
+// ErrNotFound is wrapped by errors of Download when the object does not exist.
+var ErrNotFound = errors.New("object not found")
+
+// ErrChecksumMismatch is returned when a downloaded object does not match the SHA-256 digest in its metadata.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// digestReader counts and hashes bytes read through it.
+type digestReader struct {
+	r io.Reader
+	n int64
+	h hash.Hash
+}
+
+func newDigestReader(r io.Reader) *digestReader {
+	return &digestReader{r: r, h: sha256.New()}
+}
+
+func (r *digestReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	r.h.Write(p[:n])
+	return n, err
+}
+
+// sum returns the hex-encoded digest of bytes read so far.
+func (r *digestReader) sum() string {
+	return hex.EncodeToString(r.h.Sum(nil))
+}
+
+// verify returns ErrChecksumMismatch if bytes read so far do not match the digest. An empty digest is not verified.
+func (r *digestReader) verify(digest string) error {
+	if digest == "" {
+		return nil
+	}
+	if got := r.sum(); got != digest {
+		return fmt.Errorf("%w: SHA-256 is %s, expected %s", ErrChecksumMismatch, got, digest)
+	}
+	return nil
+}
+
+// DeleteError is returned by Repository.Delete when some objects could not be deleted.
+type DeleteError struct {
+	Failures []DeleteFailure
+}
+
+type DeleteFailure struct {
+	Key string
+	Err error
+}
+
+func (e *DeleteError) Error() string {
+	const max = 10
+	msgs := []string{}
+	for i, f := range e.Failures {
+		if i == max {
+			msgs = append(msgs, fmt.Sprintf("and %d more", len(e.Failures)-max))
+			break
+		}
+		msgs = append(msgs, fmt.Sprintf("%q: %v", f.Key, f.Err))
+	}
+	return fmt.Sprintf("failed to delete %d objects: %s", len(e.Failures), strings.Join(msgs, ", "))
+}
+
+// Keys returns the keys which could not be deleted.
+func (e *DeleteError) Keys() []string {
+	res := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		res[i] = f.Key
+	}
+	return res
 }
 
 type Repository interface {
+	// List returns all objects in the repository along with their metadata, except trashed ones.
+	// Metadata is not returned by a MetadataRepository.
 	List(ctx context.Context) ([]RepositoryObject, error)
-	Upload(ctx context.Context, key string, r io.Reader) error
+	Upload(ctx context.Context, key string, r io.Reader, meta Metadata) error
+	// Delete deletes the objects. It returns *DeleteError if some objects could not be deleted.
 	Delete(ctx context.Context, keys []string) error
+	// Download returns the content of the object. The caller must close it.
+	// The error wraps ErrNotFound if the object does not exist.
+	Download(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// TrashPrefix is the key prefix of trashed objects.
+// A trashed object is stored at "<TrashPrefix><timestamp>/<key>", where timestamp is formatted in TrashTimeLayout.
+const TrashPrefix = ".trash/"
+
+// TrashTimeLayout is the layout of timestamps in trashed keys.
+const TrashTimeLayout = "20060102T150405Z"
+
+// TrashRepository is a Repository which can move objects to trash instead of deleting them.
+type TrashRepository interface {
+	Repository
+	// Trash moves the objects into the trash with the timestamp.
+	// It returns *DeleteError if some objects could not be moved.
+	Trash(ctx context.Context, keys []string, at time.Time) error
+	// PurgeTrash deletes objects trashed before the time, and returns their keys in the trash.
+	PurgeTrash(ctx context.Context, before time.Time) ([]string, error)
+}
+
+// MultipartRepository is a Repository which can leave incomplete multipart uploads behind, such as S3.
+type MultipartRepository interface {
+	Repository
+	// AbortMultipartUploads aborts incomplete multipart uploads initiated before the time, and returns their keys.
+	AbortMultipartUploads(ctx context.Context, before time.Time) ([]string, error)
+}
+
+// MetadataRepository is a Repository whose List does not return metadata, because getting it takes a request per object.
+// Metadata is fetched by FillMetadata only for objects which need it.
+type MetadataRepository interface {
+	Repository
+	// FillMetadata gets the metadata of objs and sets it to them.
+	FillMetadata(ctx context.Context, objs []RepositoryObject) error
+}
+
+// DigestRepository is a Repository which stores the digests of some objects aside from their metadata.
+// Neither List nor FillMetadata gets them, because only downloads verify digests.
+type DigestRepository interface {
+	Repository
+	// FillDigest gets the SHA-256 digest of obj stored aside and sets it to obj, if any.
+	FillDigest(ctx context.Context, obj *RepositoryObject) error
+}
+
+// ResumableRepository is a Repository which can resume an interrupted upload of the same content.
+type ResumableRepository interface {
+	Repository
+	// UploadResumable uploads like Upload, recording the progress in the journal
+	// so that a later call for the same key continues from it.
+	UploadResumable(ctx context.Context, key string, r io.Reader, meta Metadata, journal *Journal) error
+}
+
+// trashKey returns the key of the object in the trash.
+func trashKey(key string, at time.Time) string {
+	return TrashPrefix + at.UTC().Format(TrashTimeLayout) + "/" + key
+}
+
+// parseTrashKey returns the time when the object of the key in the trash was trashed.
+func parseTrashKey(key string) (time.Time, bool) {
+	if !strings.HasPrefix(key, TrashPrefix) {
+		return time.Time{}, false
+	}
+	ts := strings.SplitN(strings.TrimPrefix(key, TrashPrefix), "/", 2)[0]
+	t, err := time.Parse(TrashTimeLayout, ts)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
 }
@@ -0,0 +1,341 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// fsTempPrefix is the prefix of temporary files which are renamed to objects when completely written.
+	fsTempPrefix = ".smart-syncer-tmp-"
+	// fsMetadataSuffix is the suffix of files which store metadata of objects.
+	fsMetadataSuffix = ".meta.json"
+)
+
+func init() {
+	RegisterRepository("file", func(ctx context.Context, u *url.URL) (Repository, error) {
+		if u.Host != "" && u.Host != "localhost" {
+			return nil, fmt.Errorf("unsupported host %q of file URL", u.Host)
+		}
+		path := u.Path
+		if u.Opaque != "" {
+			// relative path such as "file:backup"
+			path = u.Opaque
+		}
+		if path == "" {
+			return nil, fmt.Errorf("path is missing in file URL")
+		}
+		// "file:///C:/backup" on Windows
+		if len(path) >= 3 && path[0] == '/' && path[2] == ':' {
+			path = path[1:]
+		}
+		return NewRepositoryFS(filepath.FromSlash(path)), nil
+	})
+}
+
+// RepositoryFS stores objects as files under a directory, such as a NAS mount or an external drive.
+// Metadata of an object is stored in a file next to it.
+type RepositoryFS struct {
+	root string
+}
+
+func NewRepositoryFS(root string) Repository {
+	return &RepositoryFS{
+		root: root,
+	}
+}
+
+func (s *RepositoryFS) path(key string) (string, error) {
+	return safeJoin(s.root, key)
+}
+
+func (s *RepositoryFS) List(ctx context.Context) ([]RepositoryObject, error) {
+	res := []RepositoryObject{}
+
+	err := filepath.WalkDir(s.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && path == filepath.Join(s.root, filepath.FromSlash(TrashPrefix)) {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), fsTempPrefix) || strings.HasSuffix(d.Name(), fsMetadataSuffix) {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("failed to get info of %q: %w", path, err)
+		}
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path: %w", err)
+		}
+		meta, err := s.readMetadata(path)
+		if err != nil {
+			return err
+		}
+
+		res = append(res, RepositoryObject{
+			Key:              filepath.ToSlash(rel),
+			LastModifiedUnix: info.ModTime().Unix(),
+			Size:             info.Size(),
+			Metadata:         meta,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fs listing objects failed: %w", err)
+	}
+	return res, nil
+}
+
+func (s *RepositoryFS) readMetadata(path string) (Metadata, error) {
+	b, err := os.ReadFile(path + fsMetadataSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Metadata{}, nil
+	}
+	if err != nil {
+		return Metadata{}, fmt.Errorf("failed to read metadata of %q: %w", path, err)
+	}
+	mm := map[string]string{}
+	if err := json.Unmarshal(b, &mm); err != nil {
+		return Metadata{}, fmt.Errorf("failed to decode metadata of %q: %w", path, err)
+	}
+	return metadataFromMap(mm), nil
+}
+
+// Upload writes r into a temporary file and renames it to the object,
+// so that a failed upload never leaves a partial object.
+func (s *RepositoryFS) Upload(ctx context.Context, key string, r io.Reader, meta Metadata) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	dr := newDigestReader(r)
+	tmp, err := s.writeTemp(filepath.Dir(path), dr)
+	if err != nil {
+		return fmt.Errorf("fs uploading failed: %w", err)
+	}
+	meta.SHA256 = dr.sum()
+	if err := ctx.Err(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	// The object is renamed before its metadata, so that a crash in between
+	// leaves the new object with the old metadata, which is detected as changed in the next run.
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to rename %q to %q: %w", tmp, path, err)
+	}
+
+	mm := meta.toMap()
+	if len(mm) == 0 {
+		if err := os.Remove(path + fsMetadataSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove metadata: %w", err)
+		}
+		return nil
+	}
+	b, err := json.Marshal(mm)
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	tmp, err = s.writeTemp(filepath.Dir(path), bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	if err := os.Rename(tmp, path+fsMetadataSuffix); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to rename metadata: %w", err)
+	}
+	return nil
+}
+
+// writeTemp writes r into a new temporary file in dir, and returns its path.
+func (s *RepositoryFS) writeTemp(dir string, r io.Reader) (string, error) {
+	f, err := os.CreateTemp(dir, fsTempPrefix)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	path := f.Name()
+
+	buf := bufPool.Get().(*[]byte)
+	defer bufPool.Put(buf)
+
+	if _, err := io.CopyBuffer(f, r, *buf); err != nil {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return "", fmt.Errorf("failed to write %q: %w", path, err)
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return "", fmt.Errorf("failed to sync %q: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(path)
+		return "", fmt.Errorf("failed to close %q: %w", path, err)
+	}
+	return path, nil
+}
+
+func (s *RepositoryFS) Delete(ctx context.Context, keys []string) error {
+	delErr := &DeleteError{}
+	for _, k := range keys {
+		if err := ctx.Err(); err != nil {
+			delErr.Failures = append(delErr.Failures, DeleteFailure{Key: k, Err: err})
+			continue
+		}
+		if err := s.delete(k); err != nil {
+			delErr.Failures = append(delErr.Failures, DeleteFailure{Key: k, Err: err})
+		}
+	}
+
+	if len(delErr.Failures) > 0 {
+		return delErr
+	}
+	return nil
+}
+
+func (s *RepositoryFS) delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	for _, p := range []string{path, path + fsMetadataSuffix} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	s.removeEmptyDirs(filepath.Dir(path))
+	return nil
+}
+
+// removeEmptyDirs removes dir and its parents while they are empty, up to the root.
+func (s *RepositoryFS) removeEmptyDirs(dir string) {
+	root := filepath.Clean(s.root)
+	for dir != root && strings.HasPrefix(dir, root) {
+		// os.Remove fails if the directory is not empty
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+func (s *RepositoryFS) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("fs downloading failed: %q: %w", key, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fs downloading failed: %w", err)
+	}
+	return f, nil
+}
+
+func (s *RepositoryFS) Trash(ctx context.Context, keys []string, at time.Time) error {
+	delErr := &DeleteError{}
+	for _, k := range keys {
+		if err := ctx.Err(); err != nil {
+			delErr.Failures = append(delErr.Failures, DeleteFailure{Key: k, Err: err})
+			continue
+		}
+		if err := s.trash(k, at); err != nil {
+			delErr.Failures = append(delErr.Failures, DeleteFailure{Key: k, Err: err})
+		}
+	}
+
+	if len(delErr.Failures) > 0 {
+		return delErr
+	}
+	return nil
+}
+
+func (s *RepositoryFS) trash(key string, at time.Time) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	dest, err := s.path(trashKey(key, at))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0777); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.Rename(path, dest); err != nil {
+		return fmt.Errorf("failed to move into trash: %w", err)
+	}
+	if err := os.Rename(path+fsMetadataSuffix, dest+fsMetadataSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to move metadata into trash: %w", err)
+	}
+	s.removeEmptyDirs(filepath.Dir(path))
+	return nil
+}
+
+func (s *RepositoryFS) PurgeTrash(ctx context.Context, before time.Time) ([]string, error) {
+	trashDir := filepath.Join(s.root, filepath.FromSlash(TrashPrefix))
+	entries, err := os.ReadDir(trashDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trash: %w", err)
+	}
+
+	keys := []string{}
+	for _, e := range entries {
+		at, ok := parseTrashKey(TrashPrefix + e.Name() + "/")
+		if !ok || !at.Before(before) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		dir := filepath.Join(trashDir, e.Name())
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || strings.HasSuffix(d.Name(), fsMetadataSuffix) {
+				return nil
+			}
+			rel, err := filepath.Rel(s.root, path)
+			if err != nil {
+				return fmt.Errorf("failed to get relative path: %w", err)
+			}
+			keys = append(keys, filepath.ToSlash(rel))
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk trash: %w", err)
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return nil, fmt.Errorf("failed to purge %q: %w", dir, err)
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,144 @@
+package syncer_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hareku/smart-syncer/pkg/syncer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositoryFS(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repository-fs-test-")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, os.RemoveAll(dir))
+	})
+
+	repo := syncer.NewRepositoryFS(dir)
+	ctx := context.Background()
+
+	begin := time.Now().Add(-time.Second).Unix()
+	meta := syncer.Metadata{
+		Fingerprint:       "h1:abc",
+		LocalModifiedUnix: 10,
+		LocalFiles:        2,
+		LocalSize:         30,
+		Format:            "tar+zstd",
+		Version:           "v1.2.3",
+		Hostname:          "host",
+	}
+	require.NoError(t, repo.Upload(ctx, "abc.tar", strings.NewReader("data for abc"), meta))
+	require.NoError(t, repo.Upload(ctx, "def/ghi.tar", strings.NewReader("data for ghi"), syncer.Metadata{}))
+
+	t.Run("list", func(t *testing.T) {
+		got, err := repo.List(ctx)
+		require.NoError(t, err)
+		require.Len(t, got, 2)
+		assert.Equal(t, "abc.tar", got[0].Key)
+		meta := meta
+		meta.SHA256 = fmt.Sprintf("%x", sha256.Sum256([]byte("data for abc")))
+		assert.Equal(t, meta, got[0].Metadata)
+		assert.GreaterOrEqual(t, got[0].LastModifiedUnix, begin)
+		assert.Equal(t, "def/ghi.tar", got[1].Key)
+		assert.Equal(t, syncer.Metadata{SHA256: fmt.Sprintf("%x", sha256.Sum256([]byte("data for ghi")))}, got[1].Metadata)
+	})
+
+	t.Run("download", func(t *testing.T) {
+		rc, err := repo.Download(ctx, "def/ghi.tar")
+		require.NoError(t, err)
+		b, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		assert.Equal(t, "data for ghi", string(b))
+
+		_, err = repo.Download(ctx, "missing.tar")
+		assert.ErrorIs(t, err, syncer.ErrNotFound)
+	})
+
+	t.Run("failed upload leaves nothing", func(t *testing.T) {
+		r := io.MultiReader(strings.NewReader("partial"), &errReader{err: errors.New("broken")})
+		require.Error(t, repo.Upload(ctx, "abc.tar", r, syncer.Metadata{}))
+
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		names := []string{}
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		assert.ElementsMatch(t, []string{"abc.tar", "abc.tar.meta.json", "def"}, names)
+
+		b, err := os.ReadFile(filepath.Join(dir, "abc.tar"))
+		require.NoError(t, err)
+		assert.Equal(t, "data for abc", string(b))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, repo.Delete(ctx, []string{"abc.tar", "def/ghi.tar"}))
+		got, err := repo.List(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, got)
+		assert.NoDirExists(t, filepath.Join(dir, "def"))
+		assert.DirExists(t, dir)
+	})
+}
+
+func TestRepositoryFS_Trash(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repository-fs-test-")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, os.RemoveAll(dir))
+	})
+
+	repo, ok := syncer.NewRepositoryFS(dir).(syncer.TrashRepository)
+	require.True(t, ok)
+	ctx := context.Background()
+
+	meta := syncer.Metadata{
+		Fingerprint:       "h1:abc",
+		LocalModifiedUnix: 10,
+		LocalFiles:        2,
+		LocalSize:         30,
+		Format:            "tar+zstd",
+		Version:           "v1.2.3",
+		Hostname:          "host",
+	}
+	require.NoError(t, repo.Upload(ctx, "abc.tar", strings.NewReader("data for abc"), meta))
+	require.NoError(t, repo.Upload(ctx, "def/ghi.tar", strings.NewReader("data for ghi"), syncer.Metadata{}))
+
+	old := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, repo.Trash(ctx, []string{"abc.tar"}, old))
+	require.NoError(t, repo.Trash(ctx, []string{"def/ghi.tar"}, old.Add(24*time.Hour)))
+
+	got, err := repo.List(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+
+	b, err := os.ReadFile(filepath.Join(dir, ".trash", "20220101T000000Z", "abc.tar"))
+	require.NoError(t, err)
+	assert.Equal(t, "data for abc", string(b))
+	assert.FileExists(t, filepath.Join(dir, ".trash", "20220101T000000Z", "abc.tar.meta.json"))
+
+	purged, err := repo.PurgeTrash(ctx, old.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []string{".trash/20220101T000000Z/abc.tar"}, purged)
+	assert.NoDirExists(t, filepath.Join(dir, ".trash", "20220101T000000Z"))
+	assert.FileExists(t, filepath.Join(dir, ".trash", "20220102T000000Z", "def", "ghi.tar"))
+}
+
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
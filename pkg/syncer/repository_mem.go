@@ -0,0 +1,136 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterRepository("mem", func(ctx context.Context, u *url.URL) (Repository, error) {
+		memReposMu.Lock()
+		defer memReposMu.Unlock()
+		repo, ok := memRepos[u.Host]
+		if !ok {
+			repo = NewRepositoryMem().(*RepositoryMem)
+			memRepos[u.Host] = repo
+		}
+		return repo, nil
+	})
+}
+
+var (
+	memReposMu sync.Mutex
+	// memRepos are repositories opened by "mem://<name>" URLs,
+	// so that the same name refers to the same repository within the process.
+	memRepos = map[string]*RepositoryMem{}
+)
+
+type memObject struct {
+	data         []byte
+	lastModified int64
+	metadata     Metadata
+}
+
+// RepositoryMem stores objects in memory. It is useful for testing and trying options.
+type RepositoryMem struct {
+	mu      sync.RWMutex
+	objects map[string]*memObject
+}
+
+func NewRepositoryMem() Repository {
+	return &RepositoryMem{
+		objects: map[string]*memObject{},
+	}
+}
+
+func (s *RepositoryMem) List(ctx context.Context) ([]RepositoryObject, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := make([]RepositoryObject, 0, len(s.objects))
+	for k, o := range s.objects {
+		if strings.HasPrefix(k, TrashPrefix) {
+			continue
+		}
+		res = append(res, RepositoryObject{
+			Key:              k,
+			LastModifiedUnix: o.lastModified,
+			Size:             int64(len(o.data)),
+			Metadata:         o.metadata,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Key < res[j].Key
+	})
+	return res, nil
+}
+
+func (s *RepositoryMem) Upload(ctx context.Context, key string, r io.Reader, meta Metadata) error {
+	dr := newDigestReader(r)
+	b, err := io.ReadAll(dr)
+	if err != nil {
+		return fmt.Errorf("mem uploading failed: %w", err)
+	}
+	meta.SHA256 = dr.sum()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = &memObject{
+		data:         b,
+		lastModified: time.Now().Unix(),
+		metadata:     meta,
+	}
+	return nil
+}
+
+func (s *RepositoryMem) Delete(ctx context.Context, keys []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range keys {
+		delete(s.objects, k)
+	}
+	return nil
+}
+
+func (s *RepositoryMem) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	o, ok := s.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("mem downloading failed: %q: %w", key, ErrNotFound)
+	}
+	return io.NopCloser(bytes.NewReader(o.data)), nil
+}
+
+func (s *RepositoryMem) Trash(ctx context.Context, keys []string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range keys {
+		if o, ok := s.objects[k]; ok {
+			s.objects[trashKey(k, at)] = o
+			delete(s.objects, k)
+		}
+	}
+	return nil
+}
+
+func (s *RepositoryMem) PurgeTrash(ctx context.Context, before time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []string{}
+	for k := range s.objects {
+		if at, ok := parseTrashKey(k); ok && at.Before(before) {
+			keys = append(keys, k)
+			delete(s.objects, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
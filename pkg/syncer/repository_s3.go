@@ -1,39 +1,212 @@
 package syncer
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+	"golang.org/x/sync/errgroup"
 )
 
+func init() {
+	RegisterRepository("s3", NewRepositoryS3Opener(&RepositoryS3Options{}))
+}
+
+// RepositoryS3Options configures repositories opened by "s3://<bucket>/<prefix>" URLs.
+type RepositoryS3Options struct {
+	// Config is the base config of AWS sessions, which query parameters of URLs override.
+	Config *aws.Config
+	// Profile is the profile of the shared config and credentials files.
+	// The "profile" query parameter of URLs overrides it.
+	Profile string
+	// RoleARN is the role to assume, if not empty.
+	RoleARN string
+	// ExternalID is passed when assuming RoleARN, if not empty.
+	ExternalID string
+	// Concurrency is the number of concurrent requests for fetching object metadata.
+	Concurrency int
+	// PartConcurrency is the number of concurrent part uploads of each multipart upload.
+	PartConcurrency int
+	// Tags are object tags of uploaded objects, such as for lifecycle rules and cost allocation.
+	Tags map[string]string
+}
+
+// NewRepositoryS3Opener returns an opener of "s3://<bucket>/<prefix>" URLs.
+// The URLs may have the following query parameters:
+//
+//	region:     region of the bucket
+//	endpoint:   endpoint of an S3 compatible service, such as "http://127.0.0.1:9000"
+//	path_style: "true" to use path style addressing, which S3 compatible services often require
+//	profile:    profile of the shared config and credentials files
+//
+// Credentials are resolved by the standard chain of the AWS SDK,
+// including environment variables, shared files and instance roles, unless Config has them.
+func NewRepositoryS3Opener(opts *RepositoryS3Options) RepositoryOpener {
+	return func(ctx context.Context, u *url.URL) (Repository, error) {
+		if u.Host == "" {
+			return nil, fmt.Errorf("bucket is missing in %q", u.Redacted())
+		}
+
+		cfg := aws.NewConfig()
+		if opts.Config != nil {
+			cfg = opts.Config.Copy()
+		}
+		q := u.Query()
+		if v := q.Get("region"); v != "" {
+			cfg = cfg.WithRegion(v)
+		}
+		if v := q.Get("endpoint"); v != "" {
+			cfg = cfg.WithEndpoint(v)
+		}
+		if v := q.Get("path_style"); v != "" {
+			pathStyle, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid path_style %q: %w", v, err)
+			}
+			cfg = cfg.WithS3ForcePathStyle(pathStyle)
+		}
+		profile := opts.Profile
+		if v := q.Get("profile"); v != "" {
+			profile = v
+		}
+
+		sess, err := session.NewSessionWithOptions(session.Options{
+			Config:            *cfg,
+			Profile:           profile,
+			SharedConfigState: session.SharedConfigEnable,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create aws session: %w", err)
+		}
+		if opts.RoleARN != "" {
+			creds := stscreds.NewCredentials(sess, opts.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+				p.RoleSessionName = "smart-syncer"
+				if opts.ExternalID != "" {
+					p.ExternalID = aws.String(opts.ExternalID)
+				}
+			})
+			sess = sess.Copy(aws.NewConfig().WithCredentials(creds))
+		}
+		api := s3.New(sess)
+
+		uploader := s3manager.NewUploaderWithClient(api, func(u *s3manager.Uploader) {
+			// RepositoryS3 aborts failed uploads itself, even if the context is canceled.
+			u.LeavePartsOnError = true
+			if opts.PartConcurrency > 0 {
+				u.Concurrency = opts.PartConcurrency
+			}
+		})
+		return NewRepositoryS3(&NewRepositoryS3Input{
+			Bucket:          u.Host,
+			Prefix:          u.Path,
+			API:             api,
+			Uploader:        uploader,
+			Concurrency:     opts.Concurrency,
+			PartConcurrency: opts.PartConcurrency,
+			Tags:            opts.Tags,
+		}), nil
+	}
+}
+
 type RepositoryS3 struct {
-	bucket   string
-	prefix   string // prefix with "/" suffix of S3 bucket
-	api      s3iface.S3API
-	uploader s3manageriface.UploaderAPI
+	bucket          string
+	prefix          string // prefix with "/" suffix of S3 bucket, or empty for the whole bucket
+	api             s3iface.S3API
+	uploader        s3manageriface.UploaderAPI
+	concurrency     int
+	partSize        int64
+	partConcurrency int
+	// buffers are buffers of the part size.
+	buffers sync.Pool
+	// tagging is the URL-encoded tags of uploaded objects, or nil for none.
+	tagging *string
 }
 
 type NewRepositoryS3Input struct {
-	Bucket   string
-	Prefix   string
-	API      s3iface.S3API
+	Bucket string
+	Prefix string
+	API    s3iface.S3API
+	// Uploader should leave parts on error, because Upload aborts failed multipart uploads by API.
 	Uploader s3manageriface.UploaderAPI
+	// Concurrency is the number of concurrent requests for fetching object metadata.
+	Concurrency int
+	// PartSize is the size of parts of resumable uploads. It is s3DefaultPartSize if zero.
+	PartSize int64
+	// PartConcurrency is the number of concurrent part uploads of resumable uploads.
+	// It is s3manager.DefaultUploadConcurrency if zero, like Uploader.
+	PartConcurrency int
+	// Tags are object tags of uploaded objects. They are not returned by List.
+	Tags map[string]string
 }
 
+// s3DefaultPartSize is the default size of parts of resumable uploads, which allows archives up to about 156 GiB.
+const s3DefaultPartSize = 16 * 1024 * 1024
+
 func NewRepositoryS3(in *NewRepositoryS3Input) Repository {
-	return &RepositoryS3{
-		bucket:   in.Bucket,
-		prefix:   strings.Trim(in.Prefix, "/") + "/",
-		api:      in.API,
-		uploader: in.Uploader,
+	concurrency := in.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	partSize := in.PartSize
+	if partSize <= 0 {
+		partSize = s3DefaultPartSize
+	}
+	partConcurrency := in.PartConcurrency
+	if partConcurrency <= 0 {
+		partConcurrency = s3manager.DefaultUploadConcurrency
+	}
+	prefix := strings.Trim(in.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	var tagging *string
+	if len(in.Tags) > 0 {
+		q := url.Values{}
+		for k, v := range in.Tags {
+			q.Set(k, v)
+		}
+		tagging = aws.String(q.Encode())
+	}
+	s := &RepositoryS3{
+		bucket:          in.Bucket,
+		prefix:          prefix,
+		api:             in.API,
+		uploader:        in.Uploader,
+		concurrency:     concurrency,
+		partSize:        partSize,
+		partConcurrency: partConcurrency,
+		tagging:         tagging,
+	}
+	s.buffers.New = func() interface{} {
+		buf := make([]byte, partSize)
+		return &buf
 	}
+	return s
+}
+
+// objectKey returns the S3 object key of the repository key.
+func (s *RepositoryS3) objectKey(key string) string {
+	return s.prefix + key
 }
 
 func (s *RepositoryS3) List(ctx context.Context) ([]RepositoryObject, error) {
@@ -44,9 +217,14 @@ func (s *RepositoryS3) List(ctx context.Context) ([]RepositoryObject, error) {
 		Prefix: &s.prefix,
 	}, func(lovo *s3.ListObjectsV2Output, b bool) bool {
 		for _, o := range lovo.Contents {
+			key := strings.TrimPrefix(*o.Key, s.prefix)
+			if strings.HasPrefix(key, TrashPrefix) || strings.HasPrefix(key, s3DigestPrefix) {
+				continue
+			}
 			res = append(res, RepositoryObject{
-				Key:              strings.TrimPrefix(*o.Key, s.prefix),
+				Key:              key,
 				LastModifiedUnix: (*o.LastModified).Unix(),
+				Size:             aws.Int64Value(o.Size),
 			})
 		}
 		return true
@@ -57,34 +235,651 @@ func (s *RepositoryS3) List(ctx context.Context) ([]RepositoryObject, error) {
 	return res, nil
 }
 
-func (s *RepositoryS3) Upload(ctx context.Context, key string, r io.Reader) error {
-	_, err := s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+// FillMetadata gets the metadata of objs by HeadObject concurrently, because ListObjectsV2 does not return it.
+func (s *RepositoryS3) FillMetadata(ctx context.Context, objs []RepositoryObject) error {
+	eg, ctx := errgroup.WithContext(ctx)
+	ch := make(chan *RepositoryObject)
+
+	eg.Go(func() error {
+		defer close(ch)
+		for i := range objs {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case ch <- &objs[i]:
+			}
+		}
+		return nil
+	})
+	for i := 0; i < s.concurrency; i++ {
+		eg.Go(func() error {
+			for obj := range ch {
+				out, err := s.api.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+					Bucket: &s.bucket,
+					Key:    aws.String(s.objectKey(obj.Key)),
+				})
+				if err != nil {
+					return fmt.Errorf("s3 getting metadata of %q failed: %w", obj.Key, err)
+				}
+				obj.Metadata = metadataFromMap(aws.StringValueMap(out.Metadata))
+			}
+			return nil
+		})
+	}
+	return eg.Wait()
+}
+
+// Upload uploads the object with its SHA-256 digest.
+// An object smaller than the part size is read into memory to send the digest in its metadata, and a larger one
+// is uploaded in parts while hashing it, and then its digest is written aside by writeDigest.
+// Each request is validated by S3 with Content-MD5 and the signed payload digest which the SDK sends.
+func (s *RepositoryS3) Upload(ctx context.Context, key string, r io.Reader, meta Metadata) error {
+	dr := newDigestReader(r)
+	buf := s.buffers.Get().(*[]byte)
+	defer s.buffers.Put(buf)
+
+	n, err := io.ReadFull(dr, *buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		meta.SHA256 = dr.sum()
+		_, err := s.upload(ctx, key, bytes.NewReader((*buf)[:n]), meta.toMap())
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read object: %w", err)
+	}
+
+	out, err := s.upload(ctx, key, io.MultiReader(bytes.NewReader((*buf)[:n]), dr), multipartMetadata(meta))
+	if err != nil {
+		return err
+	}
+	return s.writeDigest(ctx, key, dr.sum(), aws.StringValue(out.ETag))
+}
+
+func (s *RepositoryS3) upload(ctx context.Context, key string, r io.Reader, metadata map[string]string) (*s3manager.UploadOutput, error) {
+	out, err := s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:   &s.bucket,
+		Key:      aws.String(s.objectKey(key)),
+		Body:     r,
+		Metadata: aws.StringMap(metadata),
+		Tagging:  s.tagging,
+	})
+	if err != nil {
+		var mf s3manager.MultiUploadFailure
+		if errors.As(err, &mf) && mf.UploadID() != "" {
+			if abortErr := s.abortUpload(key, mf.UploadID()); abortErr != nil {
+				return nil, fmt.Errorf("s3 uploading failed: %w, and then %v", err, abortErr)
+			}
+		}
+		return nil, fmt.Errorf("s3 uploading failed: %w", err)
+	}
+	return out, nil
+}
+
+// s3DigestPrefix is the key prefix of digests of objects uploaded in parts, whose digests are known only after
+// uploading them. Their keys follow the prefix, and they are not listed.
+const s3DigestPrefix = ".digests/"
+
+// metadataDigestSidecar marks objects whose digests are stored under s3DigestPrefix.
+const metadataDigestSidecar = "Sha256-Sidecar"
+
+// s3Digest is the content of an object under s3DigestPrefix.
+type s3Digest struct {
+	SHA256 string `json:"sha256"`
+	// ETag is the ETag of the object which the digest was computed for.
+	ETag string `json:"etag"`
+}
+
+// multipartMetadata returns the metadata of an object uploaded in parts, whose digest is written by writeDigest.
+func multipartMetadata(meta Metadata) map[string]string {
+	m := meta.toMap()
+	m[metadataDigestSidecar] = "true"
+	return m
+}
+
+// writeDigest writes the digest of the object uploaded in parts with its ETag.
+func (s *RepositoryS3) writeDigest(ctx context.Context, key string, sum string, etag string) error {
+	b, err := json.Marshal(s3Digest{SHA256: sum, ETag: etag})
+	if err != nil {
+		return fmt.Errorf("failed to encode digest: %w", err)
+	}
+	_, err = s.api.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: &s.bucket,
+		Key:    aws.String(s.objectKey(s3DigestPrefix + key)),
+		Body:   bytes.NewReader(b),
+	})
+	if err != nil {
+		return fmt.Errorf("s3 writing digest of %q failed: %w", key, err)
+	}
+	return nil
+}
+
+// FillDigest gets the digest written aside by writeDigest for an object uploaded in parts.
+// It leaves the digest of obj as it is for other objects.
+func (s *RepositoryS3) FillDigest(ctx context.Context, obj *RepositoryObject) error {
+	out, err := s.api.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
 		Bucket: &s.bucket,
-		Key:    aws.String(strings.TrimPrefix(s.prefix+key, "/")),
-		Body:   r,
+		Key:    aws.String(s.objectKey(obj.Key)),
 	})
 	if err != nil {
-		return fmt.Errorf("s3 uploading failed: %w", err)
+		return fmt.Errorf("s3 getting metadata of %q failed: %w", obj.Key, err)
+	}
+	if _, ok := canonicalMetadata(aws.StringValueMap(out.Metadata))[metadataDigestSidecar]; !ok {
+		return nil
+	}
+	sum, err := s.readDigest(ctx, obj.Key, aws.StringValue(out.ETag))
+	if err != nil {
+		return err
 	}
+	obj.Metadata.SHA256 = sum
 	return nil
 }
 
+// readDigest returns the digest written by writeDigest for the object with the ETag.
+// It returns empty if the digest was not written, or was written for another object with the key.
+func (s *RepositoryS3) readDigest(ctx context.Context, key string, etag string) (string, error) {
+	out, err := s.api.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    aws.String(s.objectKey(s3DigestPrefix + key)),
+	})
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchKey {
+		log.Printf("Digest of %s is missing, which is not verified", key)
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("s3 reading digest of %q failed: %w", key, err)
+	}
+	defer out.Body.Close()
+
+	d := s3Digest{}
+	if err := json.NewDecoder(out.Body).Decode(&d); err != nil {
+		return "", fmt.Errorf("failed to decode digest of %q: %w", key, err)
+	}
+	if d.ETag != etag {
+		log.Printf("Digest of %s was written for another object, which is not verified", key)
+		return "", nil
+	}
+	return d.SHA256, nil
+}
+
+// UploadResumable uploads the object in parts concurrently, recording the multipart upload and its uploaded parts
+// in the journal. When the journal has an upload for the key initiated with the same metadata, parts which were
+// recorded with the same content are skipped, compared by their MD5 digests. So only archives which are reproduced
+// byte for byte, such as unencrypted ones of unchanged units, resume from the interrupted parts.
+// Up to the part concurrency of parts are read into memory at once.
+// Unlike Upload, the multipart upload is left on failure to be resumed, until cleanup-multipart aborts it.
+func (s *RepositoryS3) UploadResumable(ctx context.Context, key string, r io.Reader, meta Metadata, journal *Journal) error {
+	// bufs limits the number of parts in flight, and reuses their buffers which are allocated on demand.
+	bufs := make(chan []byte, s.partConcurrency)
+	for i := 0; i < s.partConcurrency; i++ {
+		bufs <- nil
+	}
+	defer func() {
+		for {
+			select {
+			case buf := <-bufs:
+				if buf != nil {
+					s.buffers.Put(&buf)
+				}
+			default:
+				return
+			}
+		}
+	}()
+	dr := newDigestReader(r)
+	// read returns the next part from a free buffer, and whether it is the last part.
+	read := func(ctx context.Context) ([]byte, bool, error) {
+		var buf []byte
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case buf = <-bufs:
+		}
+		if buf == nil {
+			buf = *s.buffers.Get().(*[]byte)
+		}
+		n, err := io.ReadFull(dr, buf)
+		last := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if err != nil && !last {
+			return nil, false, fmt.Errorf("failed to read object: %w", err)
+		}
+		return buf[:n], last, nil
+	}
+
+	data, last, err := read(ctx)
+	if err != nil {
+		return err
+	}
+
+	up, resuming := journal.MultipartUpload(key)
+	// an upload with other metadata cannot be completed with this object, and an object of a single part needs no upload to resume.
+	if resuming && (up.Metadata != meta || last) {
+		// an upload which cannot be aborted, such as one already aborted, is left to cleanup-multipart.
+		_ = s.abortUpload(key, up.ID)
+		if err := journal.DeleteMultipartUpload(key); err != nil {
+			return err
+		}
+		resuming = false
+	}
+	if last {
+		meta.SHA256 = dr.sum()
+		_, err := s.upload(ctx, key, bytes.NewReader(data), meta.toMap())
+		bufs <- data[:cap(data)]
+		return err
+	}
+
+	var uploaded map[int64]*s3.Part
+	if resuming {
+		uploaded, err = s.listParts(ctx, key, up.ID)
+		if err != nil {
+			// the upload may have been aborted or completed, so start a new one.
+			resuming = false
+		}
+	}
+	if !resuming {
+		out, err := s.api.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+			Bucket:   &s.bucket,
+			Key:      aws.String(s.objectKey(key)),
+			Metadata: aws.StringMap(multipartMetadata(meta)),
+			Tagging:  s.tagging,
+		})
+		if err != nil {
+			return fmt.Errorf("s3 creating multipart upload failed: %w", err)
+		}
+		up = MultipartUpload{ID: aws.StringValue(out.UploadId), Metadata: meta}
+		if err := journal.SaveMultipartUpload(key, up); err != nil {
+			return err
+		}
+	}
+
+	var mu sync.Mutex
+	parts := []*s3.CompletedPart{}
+	eg, egCtx := errgroup.WithContext(ctx)
+	for num := int64(1); ; num++ {
+		num, part := num, data
+		eg.Go(func() error {
+			defer func() {
+				bufs <- part[:cap(part)]
+			}()
+			etag, err := s.uploadPart(egCtx, key, up, uploaded, num, part)
+			if err != nil {
+				return err
+			}
+			if err := journal.SaveMultipartPart(key, num, etag); err != nil {
+				return err
+			}
+			mu.Lock()
+			parts = append(parts, &s3.CompletedPart{ETag: aws.String(etag), PartNumber: aws.Int64(num)})
+			mu.Unlock()
+			return nil
+		})
+		if last {
+			break
+		}
+
+		data, last, err = read(egCtx)
+		if err != nil {
+			break
+		}
+		if len(data) == 0 {
+			// the previous part was the last one
+			bufs <- data[:cap(data)]
+			break
+		}
+	}
+	if waitErr := eg.Wait(); waitErr != nil {
+		return waitErr
+	}
+	if err != nil {
+		return err
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return aws.Int64Value(parts[i].PartNumber) < aws.Int64Value(parts[j].PartNumber)
+	})
+
+	out, err := s.api.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          &s.bucket,
+		Key:             aws.String(s.objectKey(key)),
+		UploadId:        &up.ID,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return fmt.Errorf("s3 completing multipart upload failed: %w", err)
+	}
+	if err := journal.DeleteMultipartUpload(key); err != nil {
+		return err
+	}
+	return s.writeDigest(ctx, key, dr.sum(), aws.StringValue(out.ETag))
+}
+
+// uploadPart uploads the part of the multipart upload and returns its ETag.
+// A part recorded in up and listed in uploaded with the same content is not uploaded again.
+func (s *RepositoryS3) uploadPart(ctx context.Context, key string, up MultipartUpload, uploaded map[int64]*s3.Part, num int64, data []byte) (string, error) {
+	sum := md5.Sum(data)
+	digest := hex.EncodeToString(sum[:])
+	if p, ok := uploaded[num]; ok && strings.Trim(up.Parts[num], `"`) == digest &&
+		strings.Trim(aws.StringValue(p.ETag), `"`) == digest && aws.Int64Value(p.Size) == int64(len(data)) {
+		return aws.StringValue(p.ETag), nil
+	}
+	out, err := s.api.UploadPartWithContext(ctx, &s3.UploadPartInput{
+		Bucket:     &s.bucket,
+		Key:        aws.String(s.objectKey(key)),
+		UploadId:   &up.ID,
+		PartNumber: aws.Int64(num),
+		Body:       bytes.NewReader(data),
+	})
+	if err != nil {
+		return "", fmt.Errorf("s3 uploading part %d failed: %w", num, err)
+	}
+	return aws.StringValue(out.ETag), nil
+}
+
+// listParts returns the uploaded parts of the multipart upload by their numbers.
+func (s *RepositoryS3) listParts(ctx context.Context, key string, uploadID string) (map[int64]*s3.Part, error) {
+	res := map[int64]*s3.Part{}
+	err := s.api.ListPartsPagesWithContext(ctx, &s3.ListPartsInput{
+		Bucket:   &s.bucket,
+		Key:      aws.String(s.objectKey(key)),
+		UploadId: &uploadID,
+	}, func(out *s3.ListPartsOutput, b bool) bool {
+		for _, p := range out.Parts {
+			res[aws.Int64Value(p.PartNumber)] = p
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("s3 listing parts failed: %w", err)
+	}
+	return res, nil
+}
+
+// s3AbortTimeout limits aborting a multipart upload, which is not canceled with the upload.
+const s3AbortTimeout = 30 * time.Second
+
+// abortUpload aborts the failed multipart upload, so that its uploaded parts are not left to be billed.
+// It does not take the context of the upload, because the upload may have failed by cancellation of it.
+func (s *RepositoryS3) abortUpload(key string, uploadID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s3AbortTimeout)
+	defer cancel()
+
+	_, err := s.api.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   &s.bucket,
+		Key:      aws.String(s.objectKey(key)),
+		UploadId: &uploadID,
+	})
+	if err != nil {
+		return fmt.Errorf("s3 aborting multipart upload %q failed: %w", uploadID, err)
+	}
+	return nil
+}
+
+// AbortMultipartUploads aborts incomplete multipart uploads under the prefix initiated before the time.
+// It aborts every upload even if some fail.
+func (s *RepositoryS3) AbortMultipartUploads(ctx context.Context, before time.Time) ([]string, error) {
+	type upload struct {
+		key string
+		id  string
+	}
+	uploads := []upload{}
+	err := s.api.ListMultipartUploadsPagesWithContext(ctx, &s3.ListMultipartUploadsInput{
+		Bucket: &s.bucket,
+		Prefix: &s.prefix,
+	}, func(out *s3.ListMultipartUploadsOutput, b bool) bool {
+		for _, u := range out.Uploads {
+			if aws.TimeValue(u.Initiated).Before(before) {
+				uploads = append(uploads, upload{key: aws.StringValue(u.Key), id: aws.StringValue(u.UploadId)})
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("s3 listing multipart uploads failed: %w", err)
+	}
+
+	keys := []string{}
+	var errs []string
+	for _, u := range uploads {
+		_, err := s.api.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   &s.bucket,
+			Key:      &u.key,
+			UploadId: &u.id,
+		})
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", u.key, err))
+			continue
+		}
+		keys = append(keys, strings.TrimPrefix(u.key, s.prefix))
+	}
+	if len(errs) > 0 {
+		return keys, fmt.Errorf("s3 aborting %d multipart uploads failed: %s", len(errs), strings.Join(errs, "; "))
+	}
+	return keys, nil
+}
+
+// s3DeleteLimit is the maximum number of keys in a DeleteObjects request.
+const s3DeleteLimit = 1000
+
+// Delete deletes objects in chunks of s3DeleteLimit.
+// It tries every chunk even if some fail, and returns *DeleteError listing keys which failed.
+// Delete deletes the objects along with their digests written by writeDigest.
+// Failures of deleting digests are ignored, which are left only as small objects.
 func (s *RepositoryS3) Delete(ctx context.Context, keys []string) error {
-	ids := make([]*s3.ObjectIdentifier, len(keys))
-	for i, k := range keys {
-		ids[i] = &s3.ObjectIdentifier{
-			Key: aws.String(s.prefix + k),
+	delErr := &DeleteError{}
+	// each key takes two objects of a request
+	const chunkSize = s3DeleteLimit / 2
+	for begin := 0; begin < len(keys); begin += chunkSize {
+		end := begin + chunkSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		chunk := keys[begin:end]
+
+		if err := ctx.Err(); err != nil {
+			for _, k := range keys[begin:] {
+				delErr.Failures = append(delErr.Failures, DeleteFailure{Key: k, Err: err})
+			}
+			break
 		}
+		delErr.Failures = append(delErr.Failures, s.deleteChunk(ctx, chunk)...)
 	}
 
-	_, err := s.api.DeleteObjects(&s3.DeleteObjectsInput{
+	if len(delErr.Failures) > 0 {
+		return delErr
+	}
+	return nil
+}
+
+func (s *RepositoryS3) deleteChunk(ctx context.Context, keys []string) []DeleteFailure {
+	ids := make([]*s3.ObjectIdentifier, 0, 2*len(keys))
+	for _, k := range keys {
+		ids = append(ids,
+			&s3.ObjectIdentifier{Key: aws.String(s.objectKey(k))},
+			&s3.ObjectIdentifier{Key: aws.String(s.objectKey(s3DigestPrefix + k))},
+		)
+	}
+
+	out, err := s.api.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
 		Bucket: &s.bucket,
 		Delete: &s3.Delete{
 			Objects: ids,
+			Quiet:   aws.Bool(true),
 		},
 	})
 	if err != nil {
-		return fmt.Errorf("failed to delete objects: %w", err)
+		res := make([]DeleteFailure, len(keys))
+		for i, k := range keys {
+			res[i] = DeleteFailure{Key: k, Err: err}
+		}
+		return res
+	}
+
+	res := make([]DeleteFailure, 0, len(out.Errors))
+	for _, e := range out.Errors {
+		key := strings.TrimPrefix(aws.StringValue(e.Key), s.prefix)
+		if strings.HasPrefix(key, s3DigestPrefix) {
+			continue
+		}
+		res = append(res, DeleteFailure{
+			Key: key,
+			Err: fmt.Errorf("%s: %s", aws.StringValue(e.Code), aws.StringValue(e.Message)),
+		})
+	}
+	return res
+}
+
+func (s *RepositoryS3) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.api.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    aws.String(s.objectKey(key)),
+	})
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, fmt.Errorf("s3 downloading failed: %q: %w", key, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("s3 downloading failed: %w", err)
+	}
+	return out.Body, nil
+}
+
+// s3MaxCopySize is the maximum size of a source of CopyObject and of a part of UploadPartCopy.
+const s3MaxCopySize = 5 * 1024 * 1024 * 1024
+
+// copyObject copies the object on the server side, with a multipart copy if it is larger than s3MaxCopySize.
+func (s *RepositoryS3) copyObject(ctx context.Context, src string, dst string) error {
+	head, err := s.api.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: &s.bucket,
+		Key:    aws.String(s.objectKey(src)),
+	})
+	if err != nil {
+		return fmt.Errorf("s3 getting object metadata failed: %w", err)
+	}
+	source := aws.String((&url.URL{Path: s.bucket + "/" + s.objectKey(src)}).EscapedPath())
+	size := aws.Int64Value(head.ContentLength)
+	if size <= s3MaxCopySize {
+		_, err := s.api.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+			Bucket:     &s.bucket,
+			CopySource: source,
+			Key:        aws.String(s.objectKey(dst)),
+		})
+		if err != nil {
+			return fmt.Errorf("s3 copying object failed: %w", err)
+		}
+		return nil
+	}
+
+	out, err := s.api.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:   &s.bucket,
+		Key:      aws.String(s.objectKey(dst)),
+		Metadata: head.Metadata,
+		Tagging:  s.tagging,
+	})
+	if err != nil {
+		return fmt.Errorf("s3 creating multipart upload failed: %w", err)
+	}
+	abort := func(err error) error {
+		if abortErr := s.abortUpload(dst, aws.StringValue(out.UploadId)); abortErr != nil {
+			return fmt.Errorf("%w, and then %v", err, abortErr)
+		}
+		return err
+	}
+	parts := []*s3.CompletedPart{}
+	for num, start := int64(1), int64(0); start < size; num, start = num+1, start+s3MaxCopySize {
+		end := start + s3MaxCopySize
+		if end > size {
+			end = size
+		}
+		part, err := s.api.UploadPartCopyWithContext(ctx, &s3.UploadPartCopyInput{
+			Bucket:            &s.bucket,
+			Key:               aws.String(s.objectKey(dst)),
+			UploadId:          out.UploadId,
+			PartNumber:        aws.Int64(num),
+			CopySource:        source,
+			CopySourceRange:   aws.String(fmt.Sprintf("bytes=%d-%d", start, end-1)),
+			CopySourceIfMatch: head.ETag,
+		})
+		if err != nil {
+			return abort(fmt.Errorf("s3 copying part %d failed: %w", num, err))
+		}
+		parts = append(parts, &s3.CompletedPart{ETag: part.CopyPartResult.ETag, PartNumber: aws.Int64(num)})
+	}
+	_, err = s.api.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          &s.bucket,
+		Key:             aws.String(s.objectKey(dst)),
+		UploadId:        out.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return abort(fmt.Errorf("s3 completing multipart upload failed: %w", err))
+	}
+	return nil
+}
+
+// Trash copies the objects into the trash on the server side, in parts if they are larger than s3MaxCopySize,
+// and then deletes the originals.
+func (s *RepositoryS3) Trash(ctx context.Context, keys []string, at time.Time) error {
+	delErr := &DeleteError{}
+	copied := []string{}
+	var mu sync.Mutex
+
+	ch := make(chan string)
+	wg := sync.WaitGroup{}
+	for i := 0; i < s.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range ch {
+				err := s.copyObject(ctx, k, trashKey(k, at))
+
+				mu.Lock()
+				if err != nil {
+					delErr.Failures = append(delErr.Failures, DeleteFailure{Key: k, Err: fmt.Errorf("failed to copy into trash: %w", err)})
+				} else {
+					copied = append(copied, k)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, k := range keys {
+		ch <- k
+	}
+	close(ch)
+	wg.Wait()
+
+	if len(copied) > 0 {
+		var e *DeleteError
+		if err := s.Delete(ctx, copied); errors.As(err, &e) {
+			delErr.Failures = append(delErr.Failures, e.Failures...)
+		}
+	}
+	if len(delErr.Failures) > 0 {
+		return delErr
 	}
 	return nil
 }
+
+// PurgeTrash deletes the objects in the trash which were trashed before the time.
+func (s *RepositoryS3) PurgeTrash(ctx context.Context, before time.Time) ([]string, error) {
+	keys := []string{}
+	err := s.api.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: &s.bucket,
+		Prefix: aws.String(s.prefix + TrashPrefix),
+	}, func(lovo *s3.ListObjectsV2Output, b bool) bool {
+		for _, o := range lovo.Contents {
+			key := strings.TrimPrefix(*o.Key, s.prefix)
+			if at, ok := parseTrashKey(key); ok && at.Before(before) {
+				keys = append(keys, key)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("s3 listing trash failed: %w", err)
+	}
+
+	if err := s.Delete(ctx, keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
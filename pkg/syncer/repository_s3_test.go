@@ -0,0 +1,637 @@
+package syncer_test
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/hareku/smart-syncer/pkg/syncer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const listObjectsV2Response = `<?xml version="1.0" encoding="UTF-8"?>
+<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+	<Name>bucket</Name>
+	<Prefix>prefix/</Prefix>
+	<KeyCount>1</KeyCount>
+	<IsTruncated>false</IsTruncated>
+	<Contents>
+		<Key>prefix/abc.tar</Key>
+		<LastModified>2022-02-22T00:00:00.000Z</LastModified>
+		<Size>10</Size>
+	</Contents>
+</ListBucketResult>`
+
+func TestRepositoryS3Opener(t *testing.T) {
+	requests := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		assert.Contains(t, r.Header.Get("Authorization"), "Credential=access/")
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/bucket":
+			assert.Equal(t, "prefix/", r.URL.Query().Get("prefix"))
+			fmt.Fprint(w, listObjectsV2Response)
+		case r.Method == http.MethodHead && r.URL.Path == "/bucket/prefix/abc.tar":
+			w.Header().Set("X-Amz-Meta-Fingerprint", "h1:abc")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	opener := syncer.NewRepositoryS3Opener(&syncer.RepositoryS3Options{
+		Config: aws.NewConfig().WithCredentials(credentials.NewStaticCredentials("access", "secret", "")),
+	})
+	u, err := url.Parse("s3://bucket/prefix?region=us-east-1&path_style=true&endpoint=" + url.QueryEscape(srv.URL))
+	require.NoError(t, err)
+	repo, err := opener(context.Background(), u)
+	require.NoError(t, err)
+
+	got, err := repo.List(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []syncer.RepositoryObject{
+		{
+			Key:              "abc.tar",
+			LastModifiedUnix: 1645488000,
+			Size:             10,
+		},
+	}, got)
+	// listing does not get metadata of each object
+	assert.Equal(t, []string{"GET /bucket"}, requests)
+
+	mr, ok := repo.(syncer.MetadataRepository)
+	require.True(t, ok)
+	require.NoError(t, mr.FillMetadata(context.Background(), got))
+	assert.Equal(t, syncer.Metadata{Fingerprint: "h1:abc"}, got[0].Metadata)
+	assert.Equal(t, []string{"GET /bucket", "HEAD /bucket/prefix/abc.tar"}, requests)
+}
+
+func TestRepositoryS3_Delete(t *testing.T) {
+	chunks := []int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/bucket" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var body struct {
+			Objects []struct {
+				Key string
+			} `xml:"Object"`
+		}
+		require.NoError(t, xml.NewDecoder(r.Body).Decode(&body))
+		chunks = append(chunks, len(body.Objects))
+
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><DeleteResult>`)
+		for _, o := range body.Objects {
+			// failures of deleting digests are ignored
+			if o.Key == "prefix/bad.tar" || o.Key == "prefix/.digests/obj0.tar" {
+				fmt.Fprintf(w, `<Error><Key>%s</Key><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`, o.Key)
+			}
+		}
+		fmt.Fprint(w, `</DeleteResult>`)
+	}))
+	t.Cleanup(srv.Close)
+
+	opener := syncer.NewRepositoryS3Opener(&syncer.RepositoryS3Options{
+		Config: aws.NewConfig().WithCredentials(credentials.NewStaticCredentials("access", "secret", "")),
+	})
+	u, err := url.Parse("s3://bucket/prefix?region=us-east-1&path_style=true&endpoint=" + url.QueryEscape(srv.URL))
+	require.NoError(t, err)
+	repo, err := opener(context.Background(), u)
+	require.NoError(t, err)
+
+	keys := make([]string, 2500)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("obj%d.tar", i)
+	}
+	keys[1500] = "bad.tar"
+
+	err = repo.Delete(context.Background(), keys)
+	var delErr *syncer.DeleteError
+	require.True(t, errors.As(err, &delErr), err)
+	assert.Equal(t, []string{"bad.tar"}, delErr.Keys())
+	// each key is deleted with its digest
+	assert.Equal(t, []int{1000, 1000, 1000, 1000, 1000}, chunks)
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := repo.Delete(ctx, keys[:1])
+		require.True(t, errors.As(err, &delErr), err)
+		assert.Equal(t, keys[:1], delErr.Keys())
+	})
+}
+
+func TestRepositoryS3_Trash(t *testing.T) {
+	var mu sync.Mutex
+	copies := map[string]string{}
+	deleted := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodHead:
+			w.Header().Set("Content-Length", "10")
+		case r.Method == http.MethodPut:
+			src := r.Header.Get("X-Amz-Copy-Source")
+			if src == "bucket/prefix/bad%20key.tar" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			copies[r.URL.Path] = src
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><CopyObjectResult></CopyObjectResult>`)
+		case r.Method == http.MethodPost && r.URL.Path == "/bucket":
+			var body struct {
+				Objects []struct {
+					Key string
+				} `xml:"Object"`
+			}
+			require.NoError(t, xml.NewDecoder(r.Body).Decode(&body))
+			for _, o := range body.Objects {
+				deleted = append(deleted, o.Key)
+			}
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><DeleteResult></DeleteResult>`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	opener := syncer.NewRepositoryS3Opener(&syncer.RepositoryS3Options{
+		Config: aws.NewConfig().WithCredentials(credentials.NewStaticCredentials("access", "secret", "")).WithMaxRetries(0),
+	})
+	u, err := url.Parse("s3://bucket/prefix?region=us-east-1&path_style=true&endpoint=" + url.QueryEscape(srv.URL))
+	require.NoError(t, err)
+	repo, err := opener(context.Background(), u)
+	require.NoError(t, err)
+
+	at := time.Date(2022, 2, 22, 1, 2, 3, 0, time.UTC)
+	err = repo.(syncer.TrashRepository).Trash(context.Background(), []string{"a/b.tar", "bad key.tar"}, at)
+	var delErr *syncer.DeleteError
+	require.True(t, errors.As(err, &delErr), err)
+	assert.Equal(t, []string{"bad key.tar"}, delErr.Keys())
+
+	assert.Equal(t, map[string]string{
+		"/bucket/prefix/.trash/20220222T010203Z/a/b.tar": "bucket/prefix/a/b.tar",
+	}, copies)
+	assert.Equal(t, []string{"prefix/a/b.tar", "prefix/.digests/a/b.tar"}, deleted)
+}
+
+func TestRepositoryS3_Trash_Large(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		size   int64
+		copies []string
+	}{
+		{name: "at the limit", size: 5 << 30, copies: []string{""}},
+		{name: "over the limit", size: 6 << 30, copies: []string{"bytes=0-5368709119", "bytes=5368709120-6442450943"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var mu sync.Mutex
+			copies := []string{}
+			created := http.Header{}
+			type completedPart struct {
+				ETag       string
+				PartNumber int64
+			}
+			completed := []completedPart{}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				q := r.URL.Query()
+				switch {
+				case r.Method == http.MethodHead:
+					w.Header().Set("Content-Length", fmt.Sprint(tc.size))
+					w.Header().Set("ETag", `"big"`)
+					w.Header().Set("X-Amz-Meta-Fingerprint", "h1:big")
+				case r.Method == http.MethodPost && q.Has("uploads"):
+					created = r.Header.Clone()
+					fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><InitiateMultipartUploadResult><UploadId>copy-id</UploadId></InitiateMultipartUploadResult>`)
+				case r.Method == http.MethodPut:
+					assert.Equal(t, "bucket/prefix/big.tar", r.Header.Get("X-Amz-Copy-Source"))
+					copies = append(copies, r.Header.Get("X-Amz-Copy-Source-Range"))
+					if q.Has("partNumber") {
+						assert.Equal(t, "copy-id", q.Get("uploadId"))
+						assert.Equal(t, `"big"`, r.Header.Get("X-Amz-Copy-Source-If-Match"))
+						fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><CopyPartResult><ETag>"part%s"</ETag></CopyPartResult>`, q.Get("partNumber"))
+						return
+					}
+					fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><CopyObjectResult></CopyObjectResult>`)
+				case r.Method == http.MethodPost && q.Get("uploadId") == "copy-id":
+					var body struct {
+						Parts []completedPart `xml:"Part"`
+					}
+					require.NoError(t, xml.NewDecoder(r.Body).Decode(&body))
+					completed = body.Parts
+					fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><CompleteMultipartUploadResult></CompleteMultipartUploadResult>`)
+				case r.Method == http.MethodPost && r.URL.Path == "/bucket":
+					fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><DeleteResult></DeleteResult>`)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			t.Cleanup(srv.Close)
+
+			sess, err := session.NewSession(aws.NewConfig().
+				WithCredentials(credentials.NewStaticCredentials("access", "secret", "")).
+				WithRegion("us-east-1").WithEndpoint(srv.URL).WithS3ForcePathStyle(true).WithMaxRetries(0))
+			require.NoError(t, err)
+			repo := syncer.NewRepositoryS3(&syncer.NewRepositoryS3Input{
+				Bucket: "bucket",
+				Prefix: "prefix",
+				API:    s3.New(sess),
+			})
+
+			at := time.Date(2022, 2, 22, 1, 2, 3, 0, time.UTC)
+			require.NoError(t, repo.(syncer.TrashRepository).Trash(context.Background(), []string{"big.tar"}, at))
+			assert.Equal(t, tc.copies, copies)
+			if len(tc.copies) > 1 {
+				assert.Equal(t, []completedPart{{ETag: `"part1"`, PartNumber: 1}, {ETag: `"part2"`, PartNumber: 2}}, completed)
+				assert.Equal(t, "h1:big", created.Get("X-Amz-Meta-Fingerprint"))
+			} else {
+				assert.Empty(t, completed)
+			}
+		})
+	}
+}
+
+func TestRepositoryS3_Upload_AbortMultipart(t *testing.T) {
+	var mu sync.Mutex
+	aborted := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodPost && r.URL.Query().Has("uploads"):
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><InitiateMultipartUploadResult><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>`)
+		case r.Method == http.MethodPut && r.URL.Query().Get("partNumber") == "2":
+			w.WriteHeader(http.StatusBadRequest)
+		case r.Method == http.MethodPut:
+			w.Header().Set("ETag", `"etag"`)
+		case r.Method == http.MethodDelete:
+			aborted = append(aborted, r.URL.Path+"?uploadId="+r.URL.Query().Get("uploadId"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	opener := syncer.NewRepositoryS3Opener(&syncer.RepositoryS3Options{
+		Config:          aws.NewConfig().WithCredentials(credentials.NewStaticCredentials("access", "secret", "")).WithMaxRetries(0),
+		PartConcurrency: 1,
+	})
+	u, err := url.Parse("s3://bucket/prefix?region=us-east-1&path_style=true&endpoint=" + url.QueryEscape(srv.URL))
+	require.NoError(t, err)
+	repo, err := opener(context.Background(), u)
+	require.NoError(t, err)
+
+	// larger than the minimum part size, so that it is uploaded in two parts.
+	body := io.LimitReader(zeroReader{}, 6*1024*1024)
+	err = repo.Upload(context.Background(), "abc.tar", body, syncer.Metadata{})
+	require.Error(t, err)
+	assert.Equal(t, []string{"/bucket/prefix/abc.tar?uploadId=upload-1"}, aborted)
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+const listMultipartUploadsResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<ListMultipartUploadsResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+	<Bucket>bucket</Bucket>
+	<Prefix>prefix/</Prefix>
+	<IsTruncated>false</IsTruncated>
+	<Upload>
+		<Key>prefix/old.tar</Key>
+		<UploadId>old-upload</UploadId>
+		<Initiated>2022-02-20T00:00:00.000Z</Initiated>
+	</Upload>
+	<Upload>
+		<Key>prefix/new.tar</Key>
+		<UploadId>new-upload</UploadId>
+		<Initiated>2022-02-22T00:00:00.000Z</Initiated>
+	</Upload>
+</ListMultipartUploadsResult>`
+
+func TestRepositoryS3_AbortMultipartUploads(t *testing.T) {
+	aborted := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/bucket" && r.URL.Query().Has("uploads"):
+			assert.Equal(t, "prefix/", r.URL.Query().Get("prefix"))
+			fmt.Fprint(w, listMultipartUploadsResponse)
+		case r.Method == http.MethodDelete:
+			aborted = append(aborted, r.URL.Path+"?uploadId="+r.URL.Query().Get("uploadId"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	opener := syncer.NewRepositoryS3Opener(&syncer.RepositoryS3Options{
+		Config: aws.NewConfig().WithCredentials(credentials.NewStaticCredentials("access", "secret", "")),
+	})
+	u, err := url.Parse("s3://bucket/prefix?region=us-east-1&path_style=true&endpoint=" + url.QueryEscape(srv.URL))
+	require.NoError(t, err)
+	repo, err := opener(context.Background(), u)
+	require.NoError(t, err)
+
+	keys, err := repo.(syncer.MultipartRepository).AbortMultipartUploads(context.Background(), time.Date(2022, 2, 21, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"old.tar"}, keys)
+	assert.Equal(t, []string{"/bucket/prefix/old.tar?uploadId=old-upload"}, aborted)
+}
+
+func TestRepositoryS3_UploadResumable(t *testing.T) {
+	var mu sync.Mutex
+	parts := map[string][]byte{}
+	uploadedParts := []string{}
+	failPart := "2"
+	completed := false
+	created := http.Header{}
+	var digest []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		q := r.URL.Query()
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/bucket/prefix/.digests/abc.tar":
+			b, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			digest = b
+		case r.Method == http.MethodPost && q.Has("uploads"):
+			created = r.Header
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><InitiateMultipartUploadResult><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>`)
+		case r.Method == http.MethodPut && q.Get("uploadId") == "upload-1":
+			if q.Get("partNumber") == failPart {
+				failPart = ""
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			b, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			parts[q.Get("partNumber")] = b
+			uploadedParts = append(uploadedParts, q.Get("partNumber"))
+			w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(b)))
+		case r.Method == http.MethodGet && q.Get("uploadId") == "upload-1":
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListPartsResult><IsTruncated>false</IsTruncated>`)
+			for num, b := range parts {
+				fmt.Fprintf(w, `<Part><PartNumber>%s</PartNumber><ETag>"%x"</ETag><Size>%d</Size></Part>`, num, md5.Sum(b), len(b))
+			}
+			fmt.Fprint(w, `</ListPartsResult>`)
+		case r.Method == http.MethodPost && q.Get("uploadId") == "upload-1":
+			completed = true
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><CompleteMultipartUploadResult><ETag>"etag"</ETag></CompleteMultipartUploadResult>`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	sess, err := session.NewSession(aws.NewConfig().
+		WithCredentials(credentials.NewStaticCredentials("access", "secret", "")).
+		WithRegion("us-east-1").
+		WithEndpoint(srv.URL).
+		WithS3ForcePathStyle(true).
+		WithMaxRetries(0))
+	require.NoError(t, err)
+	repo := syncer.NewRepositoryS3(&syncer.NewRepositoryS3Input{
+		Bucket:   "bucket",
+		Prefix:   "prefix",
+		API:      s3.New(sess),
+		PartSize: 4,
+		// the first part is uploaded before the second one fails.
+		PartConcurrency: 1,
+	}).(syncer.ResumableRepository)
+
+	dir, err := os.MkdirTemp("", "repository-s3-resumable-test-")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, os.RemoveAll(dir))
+	})
+	journal, err := syncer.OpenJournal(dir)
+	require.NoError(t, err)
+
+	meta := syncer.Metadata{LocalModifiedUnix: 10}
+	err = repo.UploadResumable(context.Background(), "abc.tar", strings.NewReader("aaaabbbbcc"), meta, journal)
+	require.Error(t, err)
+	assert.False(t, completed)
+	up, ok := journal.MultipartUpload("abc.tar")
+	assert.True(t, ok)
+	// parts are recorded as each finishes, and the third one may have been uploaded before the failure stopped reading.
+	assert.Equal(t, fmt.Sprintf(`"%x"`, md5.Sum([]byte("aaaa"))), up.Parts[1])
+	assert.NotContains(t, up.Parts, int64(2))
+
+	// a reopened journal resumes the upload with the failed part.
+	journal, err = syncer.OpenJournal(dir)
+	require.NoError(t, err)
+	require.NoError(t, repo.UploadResumable(context.Background(), "abc.tar", strings.NewReader("aaaabbbbcc"), meta, journal))
+	assert.True(t, completed)
+	assert.ElementsMatch(t, []string{"1", "2", "3"}, uploadedParts)
+	assert.Equal(t, map[string][]byte{"1": []byte("aaaa"), "2": []byte("bbbb"), "3": []byte("cc")}, parts)
+	_, ok = journal.MultipartUpload("abc.tar")
+	assert.False(t, ok)
+
+	// the digest is written aside with the ETag of the completed object.
+	assert.Equal(t, "10", created.Get("X-Amz-Meta-Local-Modified"))
+	assert.Equal(t, "true", created.Get("X-Amz-Meta-Sha256-Sidecar"))
+	assert.JSONEq(t, fmt.Sprintf(`{"sha256":"%x","etag":"\"etag\""}`, sha256.Sum256([]byte("aaaabbbbcc"))), string(digest))
+
+	t.Run("nil journal", func(t *testing.T) {
+		mu.Lock()
+		completed = false
+		mu.Unlock()
+		require.NoError(t, repo.UploadResumable(context.Background(), "abc.tar", strings.NewReader("aaaabbbbcc"), meta, nil))
+		assert.True(t, completed)
+	})
+}
+
+func TestRepositoryS3_UploadResumable_Concurrency(t *testing.T) {
+	var mu sync.Mutex
+	inFlight := 0
+	// all parts are released once they are uploaded at the same time.
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case r.Method == http.MethodPost && q.Has("uploads"):
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><InitiateMultipartUploadResult><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>`)
+		case r.Method == http.MethodPut && q.Get("uploadId") == "upload-1":
+			b, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			mu.Lock()
+			inFlight++
+			if inFlight == 3 {
+				close(release)
+			}
+			mu.Unlock()
+			select {
+			case <-release:
+			case <-time.After(5 * time.Second):
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(b)))
+		case r.Method == http.MethodPost && q.Get("uploadId") == "upload-1":
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><CompleteMultipartUploadResult><ETag>"etag"</ETag></CompleteMultipartUploadResult>`)
+		case r.Method == http.MethodPut && r.URL.Path == "/bucket/.digests/abc.tar":
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	sess, err := session.NewSession(aws.NewConfig().
+		WithCredentials(credentials.NewStaticCredentials("access", "secret", "")).
+		WithRegion("us-east-1").
+		WithEndpoint(srv.URL).
+		WithS3ForcePathStyle(true).
+		WithMaxRetries(0))
+	require.NoError(t, err)
+	repo := syncer.NewRepositoryS3(&syncer.NewRepositoryS3Input{
+		Bucket:          "bucket",
+		API:             s3.New(sess),
+		PartSize:        4,
+		PartConcurrency: 3,
+	}).(syncer.ResumableRepository)
+
+	dir, err := os.MkdirTemp("", "repository-s3-resumable-concurrency-test-")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, os.RemoveAll(dir))
+	})
+	journal, err := syncer.OpenJournal(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, repo.UploadResumable(context.Background(), "abc.tar", strings.NewReader("aaaabbbbcc"), syncer.Metadata{}, journal))
+	_, ok := journal.MultipartUpload("abc.tar")
+	assert.False(t, ok)
+}
+
+func TestRepositoryS3_Upload_Digest(t *testing.T) {
+	var mu sync.Mutex
+	puts := map[string]http.Header{}
+	bodies := map[string][]byte{}
+	etag := `"etag"`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.Method {
+		case http.MethodPut:
+			b, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			puts[r.URL.Path] = r.Header
+			bodies[r.URL.Path] = b
+			w.Header().Set("ETag", `"etag"`)
+		case http.MethodHead:
+			for k, v := range puts[r.URL.Path] {
+				if strings.HasPrefix(k, "X-Amz-Meta-") {
+					w.Header()[k] = v
+				}
+			}
+			w.Header().Set("ETag", etag)
+		case http.MethodGet:
+			b, ok := bodies[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+				return
+			}
+			_, err := w.Write(b)
+			require.NoError(t, err)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	sess, err := session.NewSession(aws.NewConfig().
+		WithCredentials(credentials.NewStaticCredentials("access", "secret", "")).
+		WithRegion("us-east-1").
+		WithEndpoint(srv.URL).
+		WithS3ForcePathStyle(true).
+		WithMaxRetries(0))
+	require.NoError(t, err)
+	api := s3.New(sess)
+	repo := syncer.NewRepositoryS3(&syncer.NewRepositoryS3Input{
+		Bucket:   "bucket",
+		Prefix:   "prefix",
+		API:      api,
+		Uploader: s3manager.NewUploaderWithClient(api),
+		PartSize: 4,
+		Tags:     map[string]string{"env": "prod", "owner": "a b"},
+	})
+	digest := func(s string) string {
+		return fmt.Sprintf("%x", sha256.Sum256([]byte(s)))
+	}
+	ctx := context.Background()
+
+	// an object smaller than the part size is uploaded with its digest.
+	require.NoError(t, repo.Upload(ctx, "small.tar", strings.NewReader("aaa"), syncer.Metadata{}))
+	assert.Equal(t, digest("aaa"), puts["/bucket/prefix/small.tar"].Get("X-Amz-Meta-Sha256"))
+	assert.Equal(t, "env=prod&owner=a+b", puts["/bucket/prefix/small.tar"].Get("X-Amz-Tagging"))
+	assert.NotContains(t, puts, "/bucket/prefix/.digests/small.tar")
+
+	// a larger one is streamed, and then its digest is written aside with the ETag.
+	require.NoError(t, repo.Upload(ctx, "large.tar", strings.NewReader("aaaabbbbcc"), syncer.Metadata{Fingerprint: "h1:abc"}))
+	assert.Empty(t, puts["/bucket/prefix/large.tar"].Get("X-Amz-Meta-Sha256"))
+	assert.Equal(t, "true", puts["/bucket/prefix/large.tar"].Get("X-Amz-Meta-Sha256-Sidecar"))
+	assert.JSONEq(t, fmt.Sprintf(`{"sha256":%q,"etag":"\"etag\""}`, digest("aaaabbbbcc")), string(bodies["/bucket/prefix/.digests/large.tar"]))
+
+	// the digest written aside is got only by FillDigest.
+	objs := []syncer.RepositoryObject{{Key: "small.tar"}, {Key: "large.tar"}}
+	require.NoError(t, repo.(syncer.MetadataRepository).FillMetadata(ctx, objs))
+	assert.Equal(t, digest("aaa"), objs[0].Metadata.SHA256)
+	assert.Equal(t, syncer.Metadata{Fingerprint: "h1:abc"}, objs[1].Metadata)
+	require.NoError(t, repo.(syncer.DigestRepository).FillDigest(ctx, &objs[1]))
+	assert.Equal(t, syncer.Metadata{Fingerprint: "h1:abc", SHA256: digest("aaaabbbbcc")}, objs[1].Metadata)
+
+	t.Run("digest of another object", func(t *testing.T) {
+		mu.Lock()
+		etag = `"other"`
+		mu.Unlock()
+		obj := syncer.RepositoryObject{Key: "large.tar"}
+		require.NoError(t, repo.(syncer.DigestRepository).FillDigest(ctx, &obj))
+		assert.Empty(t, obj.Metadata.SHA256)
+	})
+
+	t.Run("missing digest", func(t *testing.T) {
+		mu.Lock()
+		delete(bodies, "/bucket/prefix/.digests/large.tar")
+		mu.Unlock()
+		obj := syncer.RepositoryObject{Key: "large.tar"}
+		require.NoError(t, repo.(syncer.DigestRepository).FillDigest(ctx, &obj))
+		assert.Empty(t, obj.Metadata.SHA256)
+	})
+}
@@ -0,0 +1,107 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// RetryPolicy retries a unit which failed to be uploaded by a transient error,
+// archiving it again because the archive is streamed to the repository.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, which is doubled for each retry up to MaxDelay.
+	// Each delay is randomized between half of it and itself, so that concurrent retries are spread.
+	BaseDelay time.Duration
+	// MaxDelay caps delays, if positive.
+	MaxDelay time.Duration
+}
+
+// delay returns the delay before the retry after the attempt.
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// retry calls fn until it succeeds, it fails by an error which is not retryable, or the attempts run out.
+func (c *Client) retry(ctx context.Context, unit string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || c.Retry == nil || attempt >= c.Retry.MaxAttempts || ctx.Err() != nil || !IsRetryable(err) {
+			return err
+		}
+
+		d := c.Retry.delay(attempt)
+		log.Printf("Retrying %s in %v after attempt %d failed: %v", unit, d.Round(time.Millisecond), attempt, err)
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// IsRetryable reports whether the error is likely transient, such as throttling, server errors of the repository
+// and network errors. Errors such as denied permissions, missing files and cancellation are not retryable.
+func IsRetryable(err error) bool {
+	switch {
+	case err == nil,
+		errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, fs.ErrPermission),
+		errors.Is(err, fs.ErrNotExist),
+		errors.Is(err, ErrUnsafePath),
+		errors.Is(err, ErrDecryption):
+		return false
+	case errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	}
+
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) {
+		code := reqErr.StatusCode()
+		if code == http.StatusTooManyRequests || (code >= 500 && code != http.StatusNotImplemented) {
+			return true
+		}
+	}
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		if request.IsErrorThrottle(awsErr) || request.IsErrorRetryable(awsErr) {
+			return true
+		}
+		// awserr.Error does not support errors.Unwrap.
+		if orig := awsErr.OrigErr(); orig != nil && orig != err {
+			return IsRetryable(orig)
+		}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout()
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package syncer_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/golang/mock/gomock"
+	"github.com/hareku/smart-syncer/pkg/syncer"
+	"github.com/hareku/smart-syncer/pkg/syncer/syncermock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"unknown", errors.New("unknown"), false},
+		{"canceled", fmt.Errorf("wrapped: %w", context.Canceled), false},
+		{"permission denied", fmt.Errorf("failed to open: %w", fs.ErrPermission), false},
+		{"connection reset", fmt.Errorf("failed to upload: %w", syscall.ECONNRESET), true},
+		{"unexpected EOF", io.ErrUnexpectedEOF, true},
+		{"slow down", awserr.NewRequestFailure(awserr.New("SlowDown", "Please reduce your request rate.", nil), http.StatusServiceUnavailable, "id"), true},
+		{"internal error", awserr.NewRequestFailure(awserr.New("InternalError", "", nil), http.StatusInternalServerError, "id"), true},
+		{"throttling", awserr.New("Throttling", "", nil), true},
+		{"access denied", awserr.NewRequestFailure(awserr.New("AccessDenied", "", nil), http.StatusForbidden, "id"), false},
+		{"no such bucket", awserr.NewRequestFailure(awserr.New("NoSuchBucket", "", nil), http.StatusNotFound, "id"), false},
+		{"wrapped connection reset", fmt.Errorf("failed to upload: %w", awserr.New("RequestError", "send request failed", syscall.ECONNRESET)), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, syncer.IsRetryable(tt.err))
+		})
+	}
+}
+
+func TestClient_Apply_Retry(t *testing.T) {
+	plan := &syncer.SyncPlan{
+		Path:            "target",
+		Compression:     "none",
+		RepositoryUnits: 2,
+		Actions: []syncer.SyncAction{
+			{Type: syncer.SyncActionUploadChanged, Unit: "obj1", Key: "obj1.tar"},
+		},
+	}
+	readAll := func(ctx context.Context, key string, r io.Reader, meta syncer.Metadata) error {
+		_, err := io.ReadAll(r)
+		return err
+	}
+
+	t.Run("retryable", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		repo := syncermock.NewMockRepository(ctrl)
+		gomock.InOrder(
+			repo.EXPECT().Upload(gomock.Any(), "obj1.tar", gomock.Any(), gomock.Any()).
+				DoAndReturn(func(ctx context.Context, key string, r io.Reader, meta syncer.Metadata) error {
+					if err := readAll(ctx, key, r, meta); err != nil {
+						return err
+					}
+					return awserr.NewRequestFailure(awserr.New("SlowDown", "Please reduce your request rate.", nil), http.StatusServiceUnavailable, "id")
+				}),
+			repo.EXPECT().Upload(gomock.Any(), "obj1.tar", gomock.Any(), gomock.Any()).DoAndReturn(readAll),
+		)
+		arc := syncermock.NewMockArchiver(ctrl)
+		// the unit is archived again because the archive is streamed.
+		arc.EXPECT().Do(gomock.Any(), filepath.Join("target/obj1"), gomock.Any()).Times(2).Return(nil)
+
+		c := &syncer.Client{
+			Repository:  repo,
+			Archiver:    arc,
+			Concurrency: 1,
+			Retry:       &syncer.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+		}
+		require.NoError(t, c.Apply(context.Background(), plan))
+	})
+
+	t.Run("not retryable", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		repo := syncermock.NewMockRepository(ctrl)
+		repo.EXPECT().Upload(gomock.Any(), "obj1.tar", gomock.Any(), gomock.Any()).
+			DoAndReturn(func(ctx context.Context, key string, r io.Reader, meta syncer.Metadata) error {
+				if err := readAll(ctx, key, r, meta); err != nil {
+					return err
+				}
+				return awserr.NewRequestFailure(awserr.New("AccessDenied", "Access Denied", nil), http.StatusForbidden, "id")
+			})
+		arc := syncermock.NewMockArchiver(ctrl)
+		arc.EXPECT().Do(gomock.Any(), filepath.Join("target/obj1"), gomock.Any()).Return(nil)
+
+		c := &syncer.Client{
+			Repository:  repo,
+			Archiver:    arc,
+			Concurrency: 1,
+			Retry:       &syncer.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+		}
+		err := c.Apply(context.Background(), plan)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "AccessDenied")
+	})
+
+	t.Run("attempts run out", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		repo := syncermock.NewMockRepository(ctrl)
+		repo.EXPECT().Upload(gomock.Any(), "obj1.tar", gomock.Any(), gomock.Any()).Times(2).
+			DoAndReturn(func(ctx context.Context, key string, r io.Reader, meta syncer.Metadata) error {
+				if err := readAll(ctx, key, r, meta); err != nil {
+					return err
+				}
+				return fmt.Errorf("failed to send: %w", syscall.ECONNRESET)
+			})
+		arc := syncermock.NewMockArchiver(ctrl)
+		arc.EXPECT().Do(gomock.Any(), filepath.Join("target/obj1"), gomock.Any()).Times(2).Return(nil)
+
+		c := &syncer.Client{
+			Repository:  repo,
+			Archiver:    arc,
+			Concurrency: 1,
+			Retry:       &syncer.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+		}
+		err := c.Apply(context.Background(), plan)
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, syscall.ECONNRESET), err)
+	})
+}
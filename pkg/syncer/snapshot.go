@@ -0,0 +1,322 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SnapshotPrefix is the key prefix of snapshots.
+// A snapshot is stored as a manifest at "<SnapshotPrefix>manifests/<id>.json",
+// and archives of units changed in it at "<SnapshotPrefix>units/<unit>/<id>.tar[.ext]".
+const SnapshotPrefix = ".snapshots/"
+
+// SnapshotTimeLayout is the layout of snapshot IDs, which are the UTC times when they were taken.
+const SnapshotTimeLayout = "20060102T150405Z"
+
+const (
+	snapshotManifestPrefix = SnapshotPrefix + "manifests/"
+	snapshotUnitPrefix     = SnapshotPrefix + "units/"
+)
+
+// Snapshot is the manifest of a snapshot, which records the archive of every unit at the time.
+type Snapshot struct {
+	ID    string         `json:"id"`
+	Units []SnapshotUnit `json:"units"`
+}
+
+type SnapshotUnit struct {
+	Unit string `json:"unit"`
+	// Key is the repository key of the archive, which may be shared with older snapshots if the unit was unchanged.
+	Key string `json:"key"`
+	// LastModifiedUnix is the newest modification time of the local unit when it was archived.
+	LastModifiedUnix int64    `json:"lastModifiedUnix"`
+	Metadata         Metadata `json:"metadata"`
+}
+
+func snapshotManifestKey(id string) string {
+	return snapshotManifestPrefix + id + ".json"
+}
+
+func snapshotArchiveKey(unit string, id string, comp Compression) string {
+	return archiveKey(snapshotUnitPrefix+unit+"/"+id, comp)
+}
+
+// snapshotArchiveID returns the ID of the snapshot which the archive key was uploaded for.
+func snapshotArchiveID(key string) (string, bool) {
+	if !strings.HasPrefix(key, snapshotUnitPrefix) {
+		return "", false
+	}
+	id := strings.SplitN(path.Base(key), ".", 2)[0]
+	if _, err := time.Parse(SnapshotTimeLayout, id); err != nil {
+		return "", false
+	}
+	return id, true
+}
+
+// snapshotIDs returns the IDs of snapshots in the repository objects, in ascending order.
+func snapshotIDs(objs []RepositoryObject) []string {
+	ids := []string{}
+	for _, obj := range objs {
+		if !strings.HasPrefix(obj.Key, snapshotManifestPrefix) || !strings.HasSuffix(obj.Key, ".json") {
+			continue
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(obj.Key, snapshotManifestPrefix), ".json")
+		if _, err := time.Parse(SnapshotTimeLayout, id); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (c *Client) loadSnapshot(ctx context.Context, id string) (*Snapshot, error) {
+	rc, err := c.Repository.Download(ctx, snapshotManifestKey(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download snapshot %q: %w", id, err)
+	}
+	defer rc.Close()
+
+	snap := &Snapshot{}
+	if err := json.NewDecoder(rc).Decode(snap); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot %q: %w", id, err)
+	}
+	return snap, nil
+}
+
+func (c *Client) saveSnapshot(ctx context.Context, snap *Snapshot) error {
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	if err := c.Repository.Upload(ctx, snapshotManifestKey(snap.ID), bytes.NewReader(b), Metadata{}); err != nil {
+		return fmt.Errorf("failed to upload snapshot %q: %w", snap.ID, err)
+	}
+	return nil
+}
+
+// planSnapshot plans uploading changed units and writing a new snapshot including every local unit.
+// Nothing is deleted, because older snapshots may refer to the archives.
+func (c *Client) planSnapshot(ctx context.Context, in *ClientRunInput, repoObjects []RepositoryObject) (*SyncPlan, error) {
+	id := time.Now().UTC().Format(SnapshotTimeLayout)
+	prev := &Snapshot{}
+	if ids := snapshotIDs(repoObjects); len(ids) > 0 {
+		latest := ids[len(ids)-1]
+		if latest >= id {
+			return nil, fmt.Errorf("snapshot %q already exists", latest)
+		}
+		var err error
+		prev, err = c.loadSnapshot(ctx, latest)
+		if err != nil {
+			return nil, err
+		}
+	}
+	prevUnits := map[string]SnapshotUnit{}
+	for _, u := range prev.Units {
+		prevUnits[u.Unit] = u
+	}
+
+	localObjects, err := c.listLocal(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkEmpty(in, localObjects, len(prev.Units)); err != nil {
+		return nil, err
+	}
+
+	plan := c.newPlan(in)
+	plan.RepositoryUnits = len(prev.Units)
+	plan.Snapshot = &Snapshot{ID: id, Units: make([]SnapshotUnit, 0, len(localObjects))}
+	for _, localObj := range localObjects {
+		action := SyncAction{
+			Type:             SyncActionUploadNew,
+			Unit:             localObj.Key,
+			Key:              snapshotArchiveKey(localObj.Key, id, c.compression()),
+			Fingerprint:      localObj.Fingerprint,
+			LastModifiedUnix: localObj.LastModifiedUnix,
+			Files:            localObj.Files,
+			Size:             localObj.Size,
+		}
+
+		u, ok := prevUnits[localObj.Key]
+		if ok {
+			action.Type = SyncActionUploadChanged
+			action.Reason = c.changeReason(localObj, RepositoryObject{Key: u.Key, LastModifiedUnix: u.LastModifiedUnix, Metadata: u.Metadata})
+		}
+		if ok && action.Reason == "" {
+			action.Type = SyncActionSkip
+			action.Key = u.Key
+			action.Reason = "unchanged"
+			plan.Snapshot.Units = append(plan.Snapshot.Units, u)
+		} else {
+			plan.Snapshot.Units = append(plan.Snapshot.Units, SnapshotUnit{
+				Unit:             localObj.Key,
+				Key:              action.Key,
+				LastModifiedUnix: localObj.LastModifiedUnix,
+				Metadata:         c.metadata(action),
+			})
+		}
+		plan.Actions = append(plan.Actions, action)
+	}
+	sort.Slice(plan.Snapshot.Units, func(i, j int) bool {
+		return plan.Snapshot.Units[i].Unit < plan.Snapshot.Units[j].Unit
+	})
+	return plan, nil
+}
+
+// snapshotArchives returns the archives of units in the snapshot, which is an ID or "latest".
+func (c *Client) snapshotArchives(ctx context.Context, repoObjects []RepositoryObject, id string) (map[string]RepositoryObject, error) {
+	ids := snapshotIDs(repoObjects)
+	if id == "latest" {
+		if len(ids) == 0 {
+			return nil, fmt.Errorf("no snapshot found in repository")
+		}
+		id = ids[len(ids)-1]
+	}
+	if i := sort.SearchStrings(ids, id); i == len(ids) || ids[i] != id {
+		return nil, fmt.Errorf("snapshot %q not found in repository", id)
+	}
+
+	snap, err := c.loadSnapshot(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// manifests are written from plans, so digests recorded on upload are taken from listed objects.
+	digests := map[string]string{}
+	for _, obj := range repoObjects {
+		digests[obj.Key] = obj.Metadata.SHA256
+	}
+	res := make(map[string]RepositoryObject, len(snap.Units))
+	for _, u := range snap.Units {
+		meta := u.Metadata
+		meta.SHA256 = digests[u.Key]
+		res[u.Unit] = RepositoryObject{Key: u.Key, Metadata: meta}
+	}
+	return res, nil
+}
+
+// ListSnapshots returns the IDs of snapshots in the repository, from the oldest.
+func (c *Client) ListSnapshots(ctx context.Context) ([]string, error) {
+	repoObjects, err := c.Repository.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects from repository: %w", err)
+	}
+	return snapshotIDs(repoObjects), nil
+}
+
+// ClientPruneInput is a retention policy of snapshots. A snapshot is kept if any rule selects it.
+// Periods are in UTC, and the newest snapshot is kept in each period.
+type ClientPruneInput struct {
+	// KeepLast keeps the last n snapshots.
+	KeepLast int
+	// KeepDaily keeps the last snapshot of each of the last n days which have snapshots.
+	KeepDaily int
+	// KeepWeekly keeps the last snapshot of each of the last n ISO weeks which have snapshots.
+	KeepWeekly int
+	// KeepMonthly keeps the last snapshot of each of the last n months which have snapshots.
+	KeepMonthly int
+	// MinAge keeps archives which no kept snapshot refers to if they were uploaded within the duration,
+	// because they may belong to a sync which started before the newest snapshot and has not saved its manifest yet.
+	MinAge time.Duration
+}
+
+// Prune deletes snapshots which are not kept by the policy, and archives which no kept snapshot refers to.
+// Archives of snapshots newer than the newest manifest are kept, because a sync may be uploading them concurrently.
+func (c *Client) Prune(ctx context.Context, in *ClientPruneInput) error {
+	if in.KeepLast <= 0 && in.KeepDaily <= 0 && in.KeepWeekly <= 0 && in.KeepMonthly <= 0 {
+		return fmt.Errorf("no retention rule is given, which would delete every snapshot")
+	}
+
+	repoObjects, err := c.Repository.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list objects from repository: %w", err)
+	}
+	ids := snapshotIDs(repoObjects)
+	keep := in.keep(ids)
+
+	keys := []string{}
+	used := map[string]bool{}
+	for _, id := range ids {
+		if !keep[id] {
+			log.Printf("Removing snapshot %s", id)
+			keys = append(keys, snapshotManifestKey(id))
+			continue
+		}
+		log.Printf("Keeping snapshot %s", id)
+		snap, err := c.loadSnapshot(ctx, id)
+		if err != nil {
+			return err
+		}
+		for _, u := range snap.Units {
+			used[u.Key] = true
+		}
+	}
+	newest := ""
+	if len(ids) > 0 {
+		newest = ids[len(ids)-1]
+	}
+	for _, obj := range repoObjects {
+		if !strings.HasPrefix(obj.Key, snapshotUnitPrefix) || used[obj.Key] {
+			continue
+		}
+		id, ok := snapshotArchiveID(obj.Key)
+		if !ok || id >= newest || time.Since(time.Unix(obj.LastModifiedUnix, 0)) < in.MinAge {
+			log.Printf("Keeping %s which may belong to a snapshot in progress", obj.Key)
+			continue
+		}
+		keys = append(keys, obj.Key)
+	}
+
+	for i, k := range keys {
+		log.Printf("Deleting(%d/%d): %s", i+1, len(keys), k)
+	}
+	if c.Dryrun || len(keys) == 0 {
+		return nil
+	}
+	if err := c.Repository.Delete(ctx, keys); err != nil {
+		_ = c.saveIndex(ctx) // keep deletions which succeeded in the index
+		return fmt.Errorf("failed to delete objects: %w", err)
+	}
+	return c.saveIndex(ctx)
+}
+
+// keep returns the set of snapshot IDs kept by the policy. ids must be in ascending order.
+func (in *ClientPruneInput) keep(ids []string) map[string]bool {
+	keep := map[string]bool{}
+	times := make([]time.Time, len(ids))
+	for i, id := range ids {
+		times[i], _ = time.Parse(SnapshotTimeLayout, id)
+	}
+
+	keepPeriods := func(n int, period func(t time.Time) string) {
+		last := ""
+		for i := len(ids) - 1; i >= 0 && n > 0; i-- {
+			if p := period(times[i]); p != last {
+				keep[ids[i]] = true
+				last = p
+				n--
+			}
+		}
+	}
+	keepPeriods(in.KeepLast, func(t time.Time) string {
+		return t.Format(SnapshotTimeLayout)
+	})
+	keepPeriods(in.KeepDaily, func(t time.Time) string {
+		return t.Format("2006-01-02")
+	})
+	keepPeriods(in.KeepWeekly, func(t time.Time) string {
+		y, w := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", y, w)
+	})
+	keepPeriods(in.KeepMonthly, func(t time.Time) string {
+		return t.Format("2006-01")
+	})
+	return keep
+}
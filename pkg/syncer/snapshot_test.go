@@ -0,0 +1,235 @@
+package syncer_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hareku/smart-syncer/pkg/syncer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Snapshot(t *testing.T) {
+	srcDir, err := os.MkdirTemp("", "snapshot-test-src-")
+	require.NoError(t, err)
+	destDir, err := os.MkdirTemp("", "snapshot-test-dest-")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, os.RemoveAll(srcDir))
+		assert.NoError(t, os.RemoveAll(destDir))
+	})
+
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "abc"), []byte("old abc"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "def"), []byte("data for def"), 0644))
+
+	repo := syncer.NewRepositoryMem()
+	c := &syncer.Client{
+		LocalStorage: syncer.NewLocalStorage(&syncer.NewLocalStorageInput{}),
+		Repository:   repo,
+		Archiver:     syncer.NewArchiver(&syncer.NewArchiverInput{}),
+		Extractor:    syncer.NewExtractor(&syncer.NewExtractorInput{}),
+		Fingerprint:  true,
+		Snapshot:     true,
+	}
+	ctx := context.Background()
+	in := &syncer.ClientRunInput{Path: srcDir, Depth: 1}
+
+	require.NoError(t, c.Run(ctx, in))
+	// snapshot IDs have a resolution of seconds
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "abc"), []byte("new abc"), 0644))
+	require.NoError(t, c.Run(ctx, in))
+
+	ids, err := c.ListSnapshots(ctx)
+	require.NoError(t, err)
+	require.Len(t, ids, 2)
+
+	objs, err := repo.List(ctx)
+	require.NoError(t, err)
+	keys := []string{}
+	for _, o := range objs {
+		keys = append(keys, o.Key)
+	}
+	assert.ElementsMatch(t, []string{
+		".snapshots/manifests/" + ids[0] + ".json",
+		".snapshots/manifests/" + ids[1] + ".json",
+		".snapshots/units/abc/" + ids[0] + ".tar",
+		".snapshots/units/abc/" + ids[1] + ".tar",
+		".snapshots/units/def/" + ids[0] + ".tar",
+	}, keys)
+
+	t.Run("restore", func(t *testing.T) {
+		require.NoError(t, c.Restore(ctx, &syncer.ClientRestoreInput{Path: destDir, Snapshot: ids[0]}))
+		b, err := os.ReadFile(filepath.Join(destDir, "abc"))
+		require.NoError(t, err)
+		assert.Equal(t, "old abc", string(b))
+
+		require.NoError(t, c.Restore(ctx, &syncer.ClientRestoreInput{Path: destDir, Snapshot: "latest", Keys: []string{"abc"}}))
+		b, err = os.ReadFile(filepath.Join(destDir, "abc"))
+		require.NoError(t, err)
+		assert.Equal(t, "new abc", string(b))
+	})
+
+	t.Run("prune", func(t *testing.T) {
+		require.NoError(t, c.Prune(ctx, &syncer.ClientPruneInput{KeepLast: 1}))
+
+		objs, err := repo.List(ctx)
+		require.NoError(t, err)
+		keys := []string{}
+		for _, o := range objs {
+			keys = append(keys, o.Key)
+		}
+		assert.ElementsMatch(t, []string{
+			".snapshots/manifests/" + ids[1] + ".json",
+			".snapshots/units/abc/" + ids[1] + ".tar",
+			".snapshots/units/def/" + ids[0] + ".tar",
+		}, keys)
+	})
+}
+
+// hookRepository calls beforeUpload before uploading each object.
+type hookRepository struct {
+	syncer.Repository
+	beforeUpload func(key string)
+}
+
+func (r *hookRepository) Upload(ctx context.Context, key string, rd io.Reader, meta syncer.Metadata) error {
+	r.beforeUpload(key)
+	return r.Repository.Upload(ctx, key, rd, meta)
+}
+
+func TestClient_Prune_ConcurrentSync(t *testing.T) {
+	srcDir, err := os.MkdirTemp("", "prune-concurrent-test-src-")
+	require.NoError(t, err)
+	destDir, err := os.MkdirTemp("", "prune-concurrent-test-dest-")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, os.RemoveAll(srcDir))
+		assert.NoError(t, os.RemoveAll(destDir))
+	})
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "abc"), []byte("old abc"), 0644))
+
+	repo := syncer.NewRepositoryMem()
+	pruner := &syncer.Client{Repository: repo}
+	ctx := context.Background()
+
+	pruned := false
+	c := &syncer.Client{
+		LocalStorage: syncer.NewLocalStorage(&syncer.NewLocalStorageInput{}),
+		Repository: &hookRepository{
+			Repository: repo,
+			// prune after the archive of the second snapshot is uploaded and before its manifest is.
+			beforeUpload: func(key string) {
+				ids, err := pruner.ListSnapshots(ctx)
+				require.NoError(t, err)
+				if len(ids) == 1 && strings.HasPrefix(key, ".snapshots/manifests/") {
+					require.NoError(t, pruner.Prune(ctx, &syncer.ClientPruneInput{KeepLast: 1}))
+					pruned = true
+				}
+			},
+		},
+		Archiver:    syncer.NewArchiver(&syncer.NewArchiverInput{}),
+		Extractor:   syncer.NewExtractor(&syncer.NewExtractorInput{}),
+		Fingerprint: true,
+		Snapshot:    true,
+	}
+	in := &syncer.ClientRunInput{Path: srcDir, Depth: 1}
+
+	require.NoError(t, c.Run(ctx, in))
+	// snapshot IDs have a resolution of seconds
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "abc"), []byte("new abc"), 0644))
+	require.NoError(t, c.Run(ctx, in))
+	require.True(t, pruned)
+
+	require.NoError(t, c.Restore(ctx, &syncer.ClientRestoreInput{Path: destDir, Snapshot: "latest"}))
+	b, err := os.ReadFile(filepath.Join(destDir, "abc"))
+	require.NoError(t, err)
+	assert.Equal(t, "new abc", string(b))
+
+	ids, err := c.ListSnapshots(ctx)
+	require.NoError(t, err)
+	require.Len(t, ids, 2)
+	old := ".snapshots/units/abc/" + ids[0] + ".tar"
+	keys := func() []string {
+		objs, err := repo.List(ctx)
+		require.NoError(t, err)
+		res := []string{}
+		for _, o := range objs {
+			res = append(res, o.Key)
+		}
+		return res
+	}
+
+	// the archive of the pruned snapshot is kept while it is younger than MinAge.
+	require.NoError(t, pruner.Prune(ctx, &syncer.ClientPruneInput{KeepLast: 1, MinAge: time.Hour}))
+	assert.Contains(t, keys(), old)
+	require.NoError(t, pruner.Prune(ctx, &syncer.ClientPruneInput{KeepLast: 1}))
+	assert.NotContains(t, keys(), old)
+}
+
+func TestClient_Prune(t *testing.T) {
+	ids := []string{
+		"20220101T000000Z",
+		"20220115T000000Z",
+		"20220131T000000Z",
+		"20220201T000000Z",
+		"20220202T000000Z",
+		"20220202T120000Z",
+		"20220203T000000Z",
+	}
+	tests := []struct {
+		name string
+		in   syncer.ClientPruneInput
+		want []string
+	}{
+		{
+			name: "last",
+			in:   syncer.ClientPruneInput{KeepLast: 2},
+			want: []string{"20220202T120000Z", "20220203T000000Z"},
+		},
+		{
+			name: "daily",
+			in:   syncer.ClientPruneInput{KeepDaily: 3},
+			want: []string{"20220201T000000Z", "20220202T120000Z", "20220203T000000Z"},
+		},
+		{
+			// 2022-01-31 and 2022-02-03 are in the same ISO week.
+			name: "weekly",
+			in:   syncer.ClientPruneInput{KeepWeekly: 3},
+			want: []string{"20220101T000000Z", "20220115T000000Z", "20220203T000000Z"},
+		},
+		{
+			name: "monthly and last",
+			in:   syncer.ClientPruneInput{KeepMonthly: 2, KeepLast: 1},
+			want: []string{"20220131T000000Z", "20220203T000000Z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := syncer.NewRepositoryMem()
+			ctx := context.Background()
+			for _, id := range ids {
+				manifest := fmt.Sprintf(`{"id":%q,"units":[]}`, id)
+				require.NoError(t, repo.Upload(ctx, ".snapshots/manifests/"+id+".json", strings.NewReader(manifest), syncer.Metadata{}))
+			}
+
+			c := &syncer.Client{Repository: repo}
+			require.NoError(t, c.Prune(ctx, &tt.in))
+			got, err := c.ListSnapshots(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("no rules", func(t *testing.T) {
+		c := &syncer.Client{Repository: syncer.NewRepositoryMem()}
+		assert.Error(t, c.Prune(context.Background(), &syncer.ClientPruneInput{}))
+	})
+}
@@ -0,0 +1,100 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// SourceError is returned when the source does not look like the one to be synced,
+// such as an empty mount point. Runs fail with it before changing the repository.
+type SourceError struct {
+	Path   string
+	Reason string
+}
+
+func (e *SourceError) Error() string {
+	return fmt.Sprintf("refusing to sync from %q: %s", e.Path, e.Reason)
+}
+
+// SourceCheck is a set of sanity checks of the source. Zero values disable each check,
+// except that the source must always be an existing directory.
+type SourceCheck struct {
+	// MarkerFile is a path relative to the source, which must exist.
+	MarkerFile string `json:"markerFile,omitempty"`
+	// MinObjects is the minimum number of units which the source must contain.
+	MinObjects int `json:"minObjects,omitempty"`
+	// MountPoint is a path which must be a mount point, and the source must be on the same device as it.
+	MountPoint string `json:"mountPoint,omitempty"`
+}
+
+// Check checks the source before listing its units.
+func (s *SourceCheck) Check(root string) error {
+	info, err := os.Stat(root)
+	if errors.Is(err, os.ErrNotExist) {
+		return &SourceError{Path: root, Reason: "source does not exist"}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat source: %w", err)
+	}
+	if !info.IsDir() {
+		return &SourceError{Path: root, Reason: "source is not a directory"}
+	}
+
+	if s.MarkerFile != "" {
+		_, err := os.Stat(filepath.Join(root, s.MarkerFile))
+		if errors.Is(err, os.ErrNotExist) {
+			return &SourceError{Path: root, Reason: fmt.Sprintf("marker file %q does not exist", s.MarkerFile)}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to stat marker file: %w", err)
+		}
+	}
+
+	if s.MountPoint != "" {
+		if err := s.checkMountPoint(root); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckObjects checks the units listed from the source.
+func (s *SourceCheck) CheckObjects(root string, objs []LocalObject) error {
+	if len(objs) < s.MinObjects {
+		return &SourceError{Path: root, Reason: fmt.Sprintf("source has %d units, fewer than %d", len(objs), s.MinObjects)}
+	}
+	return nil
+}
+
+func (s *SourceCheck) checkMountPoint(root string) error {
+	mp, err := filepath.Abs(s.MountPoint)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path of mount point: %w", err)
+	}
+	dev, err := deviceID(mp)
+	if err != nil {
+		return fmt.Errorf("failed to get device of mount point: %w", err)
+	}
+
+	// an unmounted mount point is just a directory on the same device as its parent.
+	if parent := filepath.Dir(mp); parent != mp {
+		parentDev, err := deviceID(parent)
+		if err != nil {
+			return fmt.Errorf("failed to get device of %q: %w", parent, err)
+		}
+		if dev == parentDev {
+			return &SourceError{Path: root, Reason: fmt.Sprintf("%q is not a mount point", s.MountPoint)}
+		}
+	}
+
+	rootDev, err := deviceID(root)
+	if err != nil {
+		return fmt.Errorf("failed to get device of source: %w", err)
+	}
+	if rootDev != dev {
+		return &SourceError{Path: root, Reason: fmt.Sprintf("source is not on the device mounted at %q", s.MountPoint)}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package syncer_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/hareku/smart-syncer/pkg/syncer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceCheck(t *testing.T) {
+	dir, err := os.MkdirTemp("", "source-check-test-")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, os.RemoveAll(dir))
+	})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".marker"), nil, 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0755))
+
+	tests := []struct {
+		name    string
+		check   syncer.SourceCheck
+		root    string
+		objs    int
+		wantErr bool
+	}{
+		{name: "ok", check: syncer.SourceCheck{MarkerFile: ".marker", MinObjects: 2}, root: dir, objs: 2},
+		{name: "missing source", root: filepath.Join(dir, "missing"), wantErr: true},
+		{name: "file source", root: filepath.Join(dir, ".marker"), wantErr: true},
+		{name: "missing marker", check: syncer.SourceCheck{MarkerFile: ".other"}, root: dir, wantErr: true},
+		{name: "too few units", check: syncer.SourceCheck{MinObjects: 3}, root: dir, objs: 2, wantErr: true},
+		{name: "not a mount point", check: syncer.SourceCheck{MountPoint: filepath.Join(dir, "sub")}, root: dir, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.check.MountPoint != "" && runtime.GOOS == "windows" {
+				t.Skip("device detection is not supported on windows")
+			}
+			err := tt.check.Check(tt.root)
+			if err == nil {
+				err = tt.check.CheckObjects(tt.root, make([]syncer.LocalObject, tt.objs))
+			}
+			if !tt.wantErr {
+				require.NoError(t, err)
+				return
+			}
+			var srcErr *syncer.SourceError
+			require.True(t, errors.As(err, &srcErr), err)
+			assert.Equal(t, tt.root, srcErr.Path)
+		})
+	}
+}
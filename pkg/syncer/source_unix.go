@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package syncer
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// deviceID returns the ID of the device containing the file.
+func deviceID(path string) (uint64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("unexpected file info %T", info.Sys())
+	}
+	return uint64(st.Dev), nil
+}
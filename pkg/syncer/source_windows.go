@@ -0,0 +1,8 @@
+package syncer
+
+import "errors"
+
+// deviceID is not supported on Windows, where drives are distinguished by volume names.
+func deviceID(path string) (uint64, error) {
+	return 0, errors.New("device detection is not supported on windows")
+}
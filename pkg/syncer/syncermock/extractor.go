@@ -0,0 +1,50 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: ./extractor.go
+
+// Package syncermock is a generated GoMock package.
+package syncermock
+
+import (
+	context "context"
+	io "io"
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockExtractor is a mock of Extractor interface.
+type MockExtractor struct {
+	ctrl     *gomock.Controller
+	recorder *MockExtractorMockRecorder
+}
+
+// MockExtractorMockRecorder is the mock recorder for MockExtractor.
+type MockExtractorMockRecorder struct {
+	mock *MockExtractor
+}
+
+// NewMockExtractor creates a new mock instance.
+func NewMockExtractor(ctrl *gomock.Controller) *MockExtractor {
+	mock := &MockExtractor{ctrl: ctrl}
+	mock.recorder = &MockExtractorMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockExtractor) EXPECT() *MockExtractorMockRecorder {
+	return m.recorder
+}
+
+// Do mocks base method.
+func (m *MockExtractor) Do(ctx context.Context, r io.Reader, dest string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Do", ctx, r, dest)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Do indicates an expected call of Do.
+func (mr *MockExtractorMockRecorder) Do(ctx, r, dest interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Do", reflect.TypeOf((*MockExtractor)(nil).Do), ctx, r, dest)
+}
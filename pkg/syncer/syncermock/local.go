@@ -35,6 +35,21 @@ func (m *MockLocalStorage) EXPECT() *MockLocalStorageMockRecorder {
 	return m.recorder
 }
 
+// Fingerprint mocks base method.
+func (m *MockLocalStorage) Fingerprint(ctx context.Context, path string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Fingerprint", ctx, path)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Fingerprint indicates an expected call of Fingerprint.
+func (mr *MockLocalStorageMockRecorder) Fingerprint(ctx, path interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Fingerprint", reflect.TypeOf((*MockLocalStorage)(nil).Fingerprint), ctx, path)
+}
+
 // List mocks base method.
 func (m *MockLocalStorage) List(ctx context.Context, path string, depth int) ([]syncer.LocalObject, error) {
 	m.ctrl.T.Helper()
@@ -8,6 +8,7 @@ import (
 	context "context"
 	io "io"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 	syncer "github.com/hareku/smart-syncer/pkg/syncer"
@@ -50,6 +51,21 @@ func (mr *MockRepositoryMockRecorder) Delete(ctx, keys interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockRepository)(nil).Delete), ctx, keys)
 }
 
+// Download mocks base method.
+func (m *MockRepository) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Download", ctx, key)
+	ret0, _ := ret[0].(io.ReadCloser)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Download indicates an expected call of Download.
+func (mr *MockRepositoryMockRecorder) Download(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Download", reflect.TypeOf((*MockRepository)(nil).Download), ctx, key)
+}
+
 // List mocks base method.
 func (m *MockRepository) List(ctx context.Context) ([]syncer.RepositoryObject, error) {
 	m.ctrl.T.Helper()
@@ -66,15 +82,506 @@ func (mr *MockRepositoryMockRecorder) List(ctx interface{}) *gomock.Call {
 }
 
 // Upload mocks base method.
-func (m *MockRepository) Upload(ctx context.Context, key string, r io.Reader) error {
+func (m *MockRepository) Upload(ctx context.Context, key string, r io.Reader, meta syncer.Metadata) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Upload", ctx, key, r)
+	ret := m.ctrl.Call(m, "Upload", ctx, key, r, meta)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Upload indicates an expected call of Upload.
-func (mr *MockRepositoryMockRecorder) Upload(ctx, key, r interface{}) *gomock.Call {
+func (mr *MockRepositoryMockRecorder) Upload(ctx, key, r, meta interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Upload", reflect.TypeOf((*MockRepository)(nil).Upload), ctx, key, r, meta)
+}
+
+// MockTrashRepository is a mock of TrashRepository interface.
+type MockTrashRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockTrashRepositoryMockRecorder
+}
+
+// MockTrashRepositoryMockRecorder is the mock recorder for MockTrashRepository.
+type MockTrashRepositoryMockRecorder struct {
+	mock *MockTrashRepository
+}
+
+// NewMockTrashRepository creates a new mock instance.
+func NewMockTrashRepository(ctrl *gomock.Controller) *MockTrashRepository {
+	mock := &MockTrashRepository{ctrl: ctrl}
+	mock.recorder = &MockTrashRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTrashRepository) EXPECT() *MockTrashRepositoryMockRecorder {
+	return m.recorder
+}
+
+// Delete mocks base method.
+func (m *MockTrashRepository) Delete(ctx context.Context, keys []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ctx, keys)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockTrashRepositoryMockRecorder) Delete(ctx, keys interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockTrashRepository)(nil).Delete), ctx, keys)
+}
+
+// Download mocks base method.
+func (m *MockTrashRepository) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Download", ctx, key)
+	ret0, _ := ret[0].(io.ReadCloser)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Download indicates an expected call of Download.
+func (mr *MockTrashRepositoryMockRecorder) Download(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Download", reflect.TypeOf((*MockTrashRepository)(nil).Download), ctx, key)
+}
+
+// List mocks base method.
+func (m *MockTrashRepository) List(ctx context.Context) ([]syncer.RepositoryObject, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", ctx)
+	ret0, _ := ret[0].([]syncer.RepositoryObject)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockTrashRepositoryMockRecorder) List(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockTrashRepository)(nil).List), ctx)
+}
+
+// PurgeTrash mocks base method.
+func (m *MockTrashRepository) PurgeTrash(ctx context.Context, before time.Time) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeTrash", ctx, before)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PurgeTrash indicates an expected call of PurgeTrash.
+func (mr *MockTrashRepositoryMockRecorder) PurgeTrash(ctx, before interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeTrash", reflect.TypeOf((*MockTrashRepository)(nil).PurgeTrash), ctx, before)
+}
+
+// Trash mocks base method.
+func (m *MockTrashRepository) Trash(ctx context.Context, keys []string, at time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Trash", ctx, keys, at)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Trash indicates an expected call of Trash.
+func (mr *MockTrashRepositoryMockRecorder) Trash(ctx, keys, at interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Trash", reflect.TypeOf((*MockTrashRepository)(nil).Trash), ctx, keys, at)
+}
+
+// Upload mocks base method.
+func (m *MockTrashRepository) Upload(ctx context.Context, key string, r io.Reader, meta syncer.Metadata) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Upload", ctx, key, r, meta)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Upload indicates an expected call of Upload.
+func (mr *MockTrashRepositoryMockRecorder) Upload(ctx, key, r, meta interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Upload", reflect.TypeOf((*MockTrashRepository)(nil).Upload), ctx, key, r, meta)
+}
+
+// MockMultipartRepository is a mock of MultipartRepository interface.
+type MockMultipartRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockMultipartRepositoryMockRecorder
+}
+
+// MockMultipartRepositoryMockRecorder is the mock recorder for MockMultipartRepository.
+type MockMultipartRepositoryMockRecorder struct {
+	mock *MockMultipartRepository
+}
+
+// NewMockMultipartRepository creates a new mock instance.
+func NewMockMultipartRepository(ctrl *gomock.Controller) *MockMultipartRepository {
+	mock := &MockMultipartRepository{ctrl: ctrl}
+	mock.recorder = &MockMultipartRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMultipartRepository) EXPECT() *MockMultipartRepositoryMockRecorder {
+	return m.recorder
+}
+
+// AbortMultipartUploads mocks base method.
+func (m *MockMultipartRepository) AbortMultipartUploads(ctx context.Context, before time.Time) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AbortMultipartUploads", ctx, before)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AbortMultipartUploads indicates an expected call of AbortMultipartUploads.
+func (mr *MockMultipartRepositoryMockRecorder) AbortMultipartUploads(ctx, before interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AbortMultipartUploads", reflect.TypeOf((*MockMultipartRepository)(nil).AbortMultipartUploads), ctx, before)
+}
+
+// Delete mocks base method.
+func (m *MockMultipartRepository) Delete(ctx context.Context, keys []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ctx, keys)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockMultipartRepositoryMockRecorder) Delete(ctx, keys interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockMultipartRepository)(nil).Delete), ctx, keys)
+}
+
+// Download mocks base method.
+func (m *MockMultipartRepository) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Download", ctx, key)
+	ret0, _ := ret[0].(io.ReadCloser)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Download indicates an expected call of Download.
+func (mr *MockMultipartRepositoryMockRecorder) Download(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Download", reflect.TypeOf((*MockMultipartRepository)(nil).Download), ctx, key)
+}
+
+// List mocks base method.
+func (m *MockMultipartRepository) List(ctx context.Context) ([]syncer.RepositoryObject, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", ctx)
+	ret0, _ := ret[0].([]syncer.RepositoryObject)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockMultipartRepositoryMockRecorder) List(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockMultipartRepository)(nil).List), ctx)
+}
+
+// Upload mocks base method.
+func (m *MockMultipartRepository) Upload(ctx context.Context, key string, r io.Reader, meta syncer.Metadata) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Upload", ctx, key, r, meta)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Upload indicates an expected call of Upload.
+func (mr *MockMultipartRepositoryMockRecorder) Upload(ctx, key, r, meta interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Upload", reflect.TypeOf((*MockMultipartRepository)(nil).Upload), ctx, key, r, meta)
+}
+
+// MockMetadataRepository is a mock of MetadataRepository interface.
+type MockMetadataRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockMetadataRepositoryMockRecorder
+}
+
+// MockMetadataRepositoryMockRecorder is the mock recorder for MockMetadataRepository.
+type MockMetadataRepositoryMockRecorder struct {
+	mock *MockMetadataRepository
+}
+
+// NewMockMetadataRepository creates a new mock instance.
+func NewMockMetadataRepository(ctrl *gomock.Controller) *MockMetadataRepository {
+	mock := &MockMetadataRepository{ctrl: ctrl}
+	mock.recorder = &MockMetadataRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMetadataRepository) EXPECT() *MockMetadataRepositoryMockRecorder {
+	return m.recorder
+}
+
+// Delete mocks base method.
+func (m *MockMetadataRepository) Delete(ctx context.Context, keys []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ctx, keys)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockMetadataRepositoryMockRecorder) Delete(ctx, keys interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockMetadataRepository)(nil).Delete), ctx, keys)
+}
+
+// Download mocks base method.
+func (m *MockMetadataRepository) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Download", ctx, key)
+	ret0, _ := ret[0].(io.ReadCloser)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Download indicates an expected call of Download.
+func (mr *MockMetadataRepositoryMockRecorder) Download(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Download", reflect.TypeOf((*MockMetadataRepository)(nil).Download), ctx, key)
+}
+
+// FillMetadata mocks base method.
+func (m *MockMetadataRepository) FillMetadata(ctx context.Context, objs []syncer.RepositoryObject) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FillMetadata", ctx, objs)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// FillMetadata indicates an expected call of FillMetadata.
+func (mr *MockMetadataRepositoryMockRecorder) FillMetadata(ctx, objs interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FillMetadata", reflect.TypeOf((*MockMetadataRepository)(nil).FillMetadata), ctx, objs)
+}
+
+// List mocks base method.
+func (m *MockMetadataRepository) List(ctx context.Context) ([]syncer.RepositoryObject, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", ctx)
+	ret0, _ := ret[0].([]syncer.RepositoryObject)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockMetadataRepositoryMockRecorder) List(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockMetadataRepository)(nil).List), ctx)
+}
+
+// Upload mocks base method.
+func (m *MockMetadataRepository) Upload(ctx context.Context, key string, r io.Reader, meta syncer.Metadata) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Upload", ctx, key, r, meta)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Upload indicates an expected call of Upload.
+func (mr *MockMetadataRepositoryMockRecorder) Upload(ctx, key, r, meta interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Upload", reflect.TypeOf((*MockMetadataRepository)(nil).Upload), ctx, key, r, meta)
+}
+
+// MockDigestRepository is a mock of DigestRepository interface.
+type MockDigestRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockDigestRepositoryMockRecorder
+}
+
+// MockDigestRepositoryMockRecorder is the mock recorder for MockDigestRepository.
+type MockDigestRepositoryMockRecorder struct {
+	mock *MockDigestRepository
+}
+
+// NewMockDigestRepository creates a new mock instance.
+func NewMockDigestRepository(ctrl *gomock.Controller) *MockDigestRepository {
+	mock := &MockDigestRepository{ctrl: ctrl}
+	mock.recorder = &MockDigestRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockDigestRepository) EXPECT() *MockDigestRepositoryMockRecorder {
+	return m.recorder
+}
+
+// Delete mocks base method.
+func (m *MockDigestRepository) Delete(ctx context.Context, keys []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ctx, keys)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockDigestRepositoryMockRecorder) Delete(ctx, keys interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockDigestRepository)(nil).Delete), ctx, keys)
+}
+
+// Download mocks base method.
+func (m *MockDigestRepository) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Download", ctx, key)
+	ret0, _ := ret[0].(io.ReadCloser)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Download indicates an expected call of Download.
+func (mr *MockDigestRepositoryMockRecorder) Download(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Download", reflect.TypeOf((*MockDigestRepository)(nil).Download), ctx, key)
+}
+
+// FillDigest mocks base method.
+func (m *MockDigestRepository) FillDigest(ctx context.Context, obj *syncer.RepositoryObject) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FillDigest", ctx, obj)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// FillDigest indicates an expected call of FillDigest.
+func (mr *MockDigestRepositoryMockRecorder) FillDigest(ctx, obj interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FillDigest", reflect.TypeOf((*MockDigestRepository)(nil).FillDigest), ctx, obj)
+}
+
+// List mocks base method.
+func (m *MockDigestRepository) List(ctx context.Context) ([]syncer.RepositoryObject, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", ctx)
+	ret0, _ := ret[0].([]syncer.RepositoryObject)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockDigestRepositoryMockRecorder) List(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockDigestRepository)(nil).List), ctx)
+}
+
+// Upload mocks base method.
+func (m *MockDigestRepository) Upload(ctx context.Context, key string, r io.Reader, meta syncer.Metadata) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Upload", ctx, key, r, meta)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Upload indicates an expected call of Upload.
+func (mr *MockDigestRepositoryMockRecorder) Upload(ctx, key, r, meta interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Upload", reflect.TypeOf((*MockDigestRepository)(nil).Upload), ctx, key, r, meta)
+}
+
+// MockResumableRepository is a mock of ResumableRepository interface.
+type MockResumableRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockResumableRepositoryMockRecorder
+}
+
+// MockResumableRepositoryMockRecorder is the mock recorder for MockResumableRepository.
+type MockResumableRepositoryMockRecorder struct {
+	mock *MockResumableRepository
+}
+
+// NewMockResumableRepository creates a new mock instance.
+func NewMockResumableRepository(ctrl *gomock.Controller) *MockResumableRepository {
+	mock := &MockResumableRepository{ctrl: ctrl}
+	mock.recorder = &MockResumableRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockResumableRepository) EXPECT() *MockResumableRepositoryMockRecorder {
+	return m.recorder
+}
+
+// Delete mocks base method.
+func (m *MockResumableRepository) Delete(ctx context.Context, keys []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ctx, keys)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockResumableRepositoryMockRecorder) Delete(ctx, keys interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockResumableRepository)(nil).Delete), ctx, keys)
+}
+
+// Download mocks base method.
+func (m *MockResumableRepository) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Download", ctx, key)
+	ret0, _ := ret[0].(io.ReadCloser)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Download indicates an expected call of Download.
+func (mr *MockResumableRepositoryMockRecorder) Download(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Download", reflect.TypeOf((*MockResumableRepository)(nil).Download), ctx, key)
+}
+
+// List mocks base method.
+func (m *MockResumableRepository) List(ctx context.Context) ([]syncer.RepositoryObject, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", ctx)
+	ret0, _ := ret[0].([]syncer.RepositoryObject)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockResumableRepositoryMockRecorder) List(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockResumableRepository)(nil).List), ctx)
+}
+
+// Upload mocks base method.
+func (m *MockResumableRepository) Upload(ctx context.Context, key string, r io.Reader, meta syncer.Metadata) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Upload", ctx, key, r, meta)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Upload indicates an expected call of Upload.
+func (mr *MockResumableRepositoryMockRecorder) Upload(ctx, key, r, meta interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Upload", reflect.TypeOf((*MockResumableRepository)(nil).Upload), ctx, key, r, meta)
+}
+
+// UploadResumable mocks base method.
+func (m *MockResumableRepository) UploadResumable(ctx context.Context, key string, r io.Reader, meta syncer.Metadata, journal *syncer.Journal) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UploadResumable", ctx, key, r, meta, journal)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UploadResumable indicates an expected call of UploadResumable.
+func (mr *MockResumableRepositoryMockRecorder) UploadResumable(ctx, key, r, meta, journal interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Upload", reflect.TypeOf((*MockRepository)(nil).Upload), ctx, key, r)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UploadResumable", reflect.TypeOf((*MockResumableRepository)(nil).UploadResumable), ctx, key, r, meta, journal)
 }
@@ -0,0 +1,230 @@
+package syncer
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/sync/errgroup"
+)
+
+type ClientVerifyInput struct {
+	// Path is the source directory which archives are compared with.
+	Path  string
+	Depth int
+	// Snapshot is the ID of the snapshot to verify, or "latest".
+	// Archives outside of snapshots are verified if it is empty.
+	Snapshot string
+}
+
+// UnitVerification is the result of comparing the archive of a unit with the unit in the source.
+// File names are relative to the unit, and directories end with "/".
+type UnitVerification struct {
+	Unit string
+	// Key is the archive of the unit, or empty if the unit has no archive in the repository.
+	Key string
+	// Local is false if the unit does not exist in the source.
+	Local bool
+	// Missing are files in the source which are missing in the archive.
+	Missing []string
+	// Extra are files in the archive which do not exist in the source.
+	Extra []string
+	// Mismatched are files whose types, sizes or contents differ between the archive and the source.
+	Mismatched []string
+}
+
+// OK reports whether the archive matches the unit.
+func (v *UnitVerification) OK() bool {
+	return v.Key != "" && v.Local && len(v.Missing) == 0 && len(v.Extra) == 0 && len(v.Mismatched) == 0
+}
+
+// tarEntry is a file in a tar stream, with the digest of its content or symlink target.
+type tarEntry struct {
+	typeflag byte
+	size     int64
+	sum      [sha256.Size]byte
+}
+
+// Verify downloads the archive of each unit and compares its files with the unit in the source,
+// which is archived by the Archiver to see the same files as sync. It returns results sorted by units.
+// Units without archives and archives without units are included, for which nothing is downloaded.
+func (c *Client) Verify(ctx context.Context, in *ClientVerifyInput) ([]UnitVerification, error) {
+	repoObjects, err := c.Repository.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects from repository: %w", err)
+	}
+	archives, err := c.unitArchives(ctx, repoObjects, in.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+	localObjects, err := c.LocalStorage.List(ctx, in.Path, in.Depth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects from local storage: %w", err)
+	}
+
+	res := make([]UnitVerification, 0, len(archives))
+	local := make(map[string]bool, len(localObjects))
+	for _, obj := range localObjects {
+		res = append(res, UnitVerification{Unit: obj.Key, Key: archives[obj.Key].Key, Local: true})
+		local[obj.Key] = true
+	}
+	for unit, obj := range archives {
+		if !local[unit] {
+			res = append(res, UnitVerification{Unit: unit, Key: obj.Key})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Unit < res[j].Unit
+	})
+
+	// only units both in the source and in the repository are downloaded.
+	queue := []*UnitVerification{}
+	for i := range res {
+		if res[i].Local && res[i].Key != "" {
+			queue = append(queue, &res[i])
+		}
+	}
+
+	eg, ctx := errgroup.WithContext(ctx)
+	ch := make(chan *UnitVerification)
+	eg.Go(func() error {
+		defer close(ch)
+		for i, v := range queue {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("verifying cancelled: %w", ctx.Err())
+			case ch <- v:
+				log.Printf("Verifying(%d/%d): %s", i+1, len(queue), v.Key)
+			}
+		}
+		return nil
+	})
+	for i := 0; i < c.concurrency(); i++ {
+		eg.Go(func() error {
+			for v := range ch {
+				if err := c.verifyUnit(ctx, in.Path, archives[v.Unit], v); err != nil {
+					return fmt.Errorf("failed to verify %q: %w", v.Unit, err)
+				}
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// verifyUnit compares the archive of the unit with the unit in the source, filling v.
+func (c *Client) verifyUnit(ctx context.Context, root string, obj RepositoryObject, v *UnitVerification) error {
+	remote, err := c.remoteEntries(ctx, obj)
+	if err != nil {
+		return err
+	}
+	local, err := c.localEntries(ctx, filepath.Join(root, v.Unit))
+	if err != nil {
+		return err
+	}
+
+	for name, l := range local {
+		r, ok := remote[name]
+		switch {
+		case !ok:
+			v.Missing = append(v.Missing, name)
+		case r != l:
+			v.Mismatched = append(v.Mismatched, name)
+		}
+	}
+	for name := range remote {
+		if _, ok := local[name]; !ok {
+			v.Extra = append(v.Extra, name)
+		}
+	}
+	sort.Strings(v.Missing)
+	sort.Strings(v.Extra)
+	sort.Strings(v.Mismatched)
+	return nil
+}
+
+// remoteEntries downloads the archive and returns its entries, verifying the archive with its digest.
+func (c *Client) remoteEntries(ctx context.Context, obj RepositoryObject) (map[string]tarEntry, error) {
+	var res map[string]tarEntry
+	err := c.download(ctx, obj, func(r io.Reader) (err error) {
+		res, err = readTarEntries(r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// localEntries archives the unit at path and returns its entries.
+func (c *Client) localEntries(ctx context.Context, path string) (map[string]tarEntry, error) {
+	pr, pw := io.Pipe()
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.Go(func() (err error) {
+		defer func() {
+			pw.CloseWithError(err)
+		}()
+		if err := c.Archiver.Do(ctx, path, pw); err != nil {
+			return fmt.Errorf("failed to archive: %w", err)
+		}
+		return nil
+	})
+	var res map[string]tarEntry
+	eg.Go(func() (err error) {
+		// unblock the archiver if reading fails
+		defer pr.Close()
+		res, err = readTarEntries(pr)
+		return err
+	})
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// readTarEntries reads the tar stream to the end and returns its entries by their names.
+func readTarEntries(r io.Reader) (map[string]tarEntry, error) {
+	buf := bufPool.Get().(*[]byte)
+	defer bufPool.Put(buf)
+
+	res := map[string]tarEntry{}
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return res, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar: %w", err)
+		}
+
+		// the root entry is the unit itself, which is not a file in the unit.
+		if h.Name == rootEntryName {
+			continue
+		}
+
+		e := tarEntry{typeflag: h.Typeflag, size: h.Size}
+		switch h.Typeflag {
+		case tar.TypeReg:
+			hash := sha256.New()
+			n, err := io.CopyBuffer(hash, tr, *buf)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %q from tar: %w", h.Name, err)
+			}
+			e.size = n
+			copy(e.sum[:], hash.Sum(nil))
+		case tar.TypeSymlink:
+			e.sum = sha256.Sum256([]byte(h.Linkname))
+		}
+		res[h.Name] = e
+	}
+}
@@ -0,0 +1,73 @@
+package syncer_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hareku/smart-syncer/pkg/syncer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Verify(t *testing.T) {
+	srcDir, err := os.MkdirTemp("", "client-verify-test-")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, os.RemoveAll(srcDir))
+	})
+
+	require.NoError(t, os.MkdirAll(filepath.Join(srcDir, "abc/sub"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "abc/changed"), []byte("old data"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "abc/removed"), []byte("data for removed"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "abc/sub/kept"), []byte("data for kept"), 0644))
+	require.NoError(t, os.Symlink("sub/kept", filepath.Join(srcDir, "abc/link")))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "def"), []byte("data for def"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "ghi"), []byte("data for ghi"), 0644))
+
+	comp, err := syncer.NewCompression("gzip")
+	require.NoError(t, err)
+	c := &syncer.Client{
+		LocalStorage: syncer.NewLocalStorage(&syncer.NewLocalStorageInput{}),
+		Repository:   syncer.NewRepositoryMem(),
+		Archiver:     syncer.NewArchiver(&syncer.NewArchiverInput{}),
+		Concurrency:  2,
+		Compression:  comp,
+		Encryption:   syncer.NewAESGCMEncryption([]byte("secret")),
+	}
+	ctx := context.Background()
+	require.NoError(t, c.Run(ctx, &syncer.ClientRunInput{Path: srcDir, Depth: 1}))
+
+	res, err := c.Verify(ctx, &syncer.ClientVerifyInput{Path: srcDir, Depth: 1})
+	require.NoError(t, err)
+	require.Len(t, res, 3)
+	for _, v := range res {
+		assert.True(t, v.OK(), v)
+	}
+
+	// the same size as the old data
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "abc/changed"), []byte("new data"), 0644))
+	require.NoError(t, os.Remove(filepath.Join(srcDir, "abc/removed")))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "abc/sub/added"), []byte("data for added"), 0644))
+	require.NoError(t, os.Remove(filepath.Join(srcDir, "abc/link")))
+	require.NoError(t, os.Symlink("changed", filepath.Join(srcDir, "abc/link")))
+	require.NoError(t, os.Remove(filepath.Join(srcDir, "ghi")))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "jkl"), []byte("data for jkl"), 0644))
+
+	res, err = c.Verify(ctx, &syncer.ClientVerifyInput{Path: srcDir, Depth: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []syncer.UnitVerification{
+		{
+			Unit:       "abc",
+			Key:        "abc.tar.gz",
+			Local:      true,
+			Missing:    []string{"sub/added"},
+			Extra:      []string{"removed"},
+			Mismatched: []string{"changed", "link"},
+		},
+		{Unit: "def", Key: "def.tar.gz", Local: true},
+		{Unit: "ghi", Key: "ghi.tar.gz"},
+		{Unit: "jkl", Local: true},
+	}, res)
+}
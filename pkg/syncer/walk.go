@@ -0,0 +1,96 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// walkFunc is called by walker for each file in lexical order, with its path relative to the root of the walk.
+// info is of the file itself for a symlink, unless the symlink is followed.
+// Returning filepath.SkipDir for a directory skips its contents.
+type walkFunc func(path string, rel string, info fs.FileInfo) error
+
+// walker walks a unit in the same way for archiving, fingerprinting and listing modification times,
+// so that all of them see the same files.
+type walker struct {
+	filter *Filter
+	// followSymlinks walks targets of symlinks instead of symlinks themselves.
+	// Symlinks whose targets do not exist are still walked as symlinks.
+	followSymlinks bool
+}
+
+// walk calls fn for root and every file under it, except excluded ones.
+func (w *walker) walk(ctx context.Context, root string, fn walkFunc) error {
+	info, err := w.stat(root)
+	if err != nil {
+		return err
+	}
+	return w.visit(ctx, root, ".", info, nil, fn)
+}
+
+func (w *walker) stat(path string) (fs.FileInfo, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %q: %w", path, err)
+	}
+	if w.followSymlinks && info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Stat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return info, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %q: %w", path, err)
+		}
+		return target, nil
+	}
+	return info, nil
+}
+
+// visit walks path. ancestors are the directories which path is in, to detect loops of followed symlinks.
+func (w *walker) visit(ctx context.Context, path string, rel string, info fs.FileInfo, ancestors []fs.FileInfo, fn walkFunc) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if rel != "." {
+		excluded, err := w.filter.Excluded(path, info.IsDir())
+		if err != nil || excluded {
+			return err
+		}
+	}
+
+	if err := fn(path, rel, info); err != nil {
+		if errors.Is(err, filepath.SkipDir) && info.IsDir() {
+			return nil
+		}
+		return err
+	}
+	if !info.IsDir() {
+		return nil
+	}
+
+	for _, a := range ancestors {
+		if os.SameFile(a, info) {
+			return fmt.Errorf("symlink loop at %q", path)
+		}
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return fmt.Errorf("failed to read directory %q: %w", path, err)
+	}
+	ancestors = append(ancestors, info)
+	for _, e := range entries {
+		p := filepath.Join(path, e.Name())
+		i, err := w.stat(p)
+		if err != nil {
+			return err
+		}
+		if err := w.visit(ctx, p, filepath.Join(rel, e.Name()), i, ancestors, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
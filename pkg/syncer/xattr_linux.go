@@ -0,0 +1,54 @@
+//go:build linux
+// +build linux
+
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// readXattrs returns the extended attributes of the file, including POSIX ACLs which are stored in them.
+func readXattrs(path string) (map[string]string, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if errors.Is(err, syscall.ENOTSUP) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list extended attributes of %q: %w", path, err)
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	buf := make([]byte, size)
+	size, err = syscall.Listxattr(path, buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list extended attributes of %q: %w", path, err)
+	}
+
+	res := map[string]string{}
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		n, err := syscall.Getxattr(path, name, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get extended attribute %q of %q: %w", name, path, err)
+		}
+		v := make([]byte, n)
+		n, err = syscall.Getxattr(path, name, v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get extended attribute %q of %q: %w", name, path, err)
+		}
+		res[name] = string(v[:n])
+	}
+	return res, nil
+}
+
+func writeXattrs(path string, attrs map[string]string) error {
+	for name, v := range attrs {
+		if err := syscall.Setxattr(path, name, []byte(v), 0); err != nil {
+			return fmt.Errorf("failed to set extended attribute %q of %q: %w", name, path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package syncer
+
+import "fmt"
+
+func readXattrs(path string) (map[string]string, error) {
+	return nil, nil
+}
+
+func writeXattrs(path string, attrs map[string]string) error {
+	if len(attrs) > 0 {
+		return fmt.Errorf("extended attributes are not supported on this platform")
+	}
+	return nil
+}